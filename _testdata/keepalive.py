@@ -0,0 +1,10 @@
+#!/usr/bin/env python
+
+import os
+import socket
+import time
+
+sock = socket.socket(socket.AF_UNIX, socket.SOCK_DGRAM)
+while True:
+    sock.sendto(b"WATCHDOG=1\n", os.environ["NOTIFY_SOCKET"])
+    time.sleep(0.2)
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	shellPath = "/bin/sh"
+	// when any of these are present, the shell
+	// can't exec() the command directly
+	shellOperators = "|&;()`\n"
+)
+
+// Argv is an exact list of arguments, which is parsed from
+// a JSON array of strings (e.g. ["/usr/bin/foo", "-name", "a b"]).
+type Argv []string
+
+func (a *Argv) Parse(input string) error {
+	var args []string
+	if err := json.Unmarshal([]byte(input), &args); err != nil {
+		return fmt.Errorf("invalid arguments %s, must be a JSON array of strings: %s", input, err)
+	}
+	*a = args
+	return nil
+}
+
+const (
+	// shell reserved words and builtins, which can't be run by exec
+	shellNoExec = "! { } [[ case do done elif else esac fi for function if in select then time until while " +
+		". : alias break cd continue eval exec exit export local read readonly return set shift source times trap ulimit umask unset wait"
+)
+
+// shellCommand returns the command to pass to sh -c. Simple
+// commands are prefixed by exec, so the shell is replaced by the
+// command and signals are delivered directly to it. Anything else
+// (pipelines, lists, subshells, compound commands, builtins or
+// commands with variable assignments) keeps the shell as the main
+// process, so services run by the shell are signaled as a process
+// group.
+func shellCommand(command string) string {
+	if !isSimpleCommand(command) {
+		return command
+	}
+	return "exec " + command
+}
+
+// isSimpleCommand returns true iff command is a single command
+// which can be run by exec.
+func isSimpleCommand(command string) bool {
+	if strings.ContainsAny(command, shellOperators) {
+		return false
+	}
+	fields := strings.Fields(command)
+	if len(fields) == 0 || isShellNoExec(fields[0]) {
+		return false
+	}
+	// VAR=value cmd
+	if p := strings.IndexByte(fields[0], '='); p > 0 && isShellName(fields[0][:p]) {
+		return false
+	}
+	return true
+}
+
+// isShellNoExec returns true iff word is a shell reserved word
+// or builtin.
+func isShellNoExec(word string) bool {
+	for _, v := range strings.Fields(shellNoExec) {
+		if v == word {
+			return true
+		}
+	}
+	return false
+}
+
+// isShellName returns true iff s is a valid shell variable name.
+func isShellName(s string) bool {
+	for ii, c := range s {
+		if c != '_' && !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && (ii == 0 || !(c >= '0' && c <= '9')) {
+			return false
+		}
+	}
+	return true
+}
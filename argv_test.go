@@ -0,0 +1,73 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestArgv(t *testing.T) {
+	lookup := envLookup(map[string]string{"NAME": "world"})
+	args := new(Argv)
+	if err := args.Parse(`["/bin/echo", "hello ${NAME}", "a  b"]`); err != nil {
+		t.Fatal(err)
+	}
+	if err := new(Argv).Parse("/bin/echo hello"); err == nil {
+		t.Error("expecting an error with non-JSON arguments")
+	}
+	tests := []struct {
+		cfg    *Config
+		expect []string
+	}{
+		{&Config{Command: "/bin/echo hello ${NAME}"}, []string{"/bin/echo", "hello", "world"}},
+		{&Config{Command: "/bin/echo hello", Args: args}, []string{"/bin/echo", "hello ${NAME}", "a  b"}},
+		{&Config{Command: "echo ${NAME} > /dev/null", Shell: true}, []string{"/bin/sh", "-c", "exec echo world > /dev/null"}},
+		{&Config{Command: "echo a | cat && true", Shell: true}, []string{"/bin/sh", "-c", "echo a | cat && true"}},
+	}
+	for _, v := range tests {
+		argv, err := v.cfg.argv(lookup)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if !reflect.DeepEqual(argv, v.expect) {
+			t.Errorf("expecting argv %q, got %q", v.expect, argv)
+		}
+	}
+	if _, err := (&Config{Args: args, Shell: true}).argv(lookup); err == nil {
+		t.Error("expecting an error with Shell and Args")
+	}
+}
+
+func TestShellCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		exec    bool
+	}{
+		{"sleep 10", true},
+		{"/usr/bin/foo --bar=baz > /dev/null", true},
+		{"/usr/bin/foo > /dev/null 2>&1", false},
+		{"./server PORT=8080", true},
+		{"PORT=8080 ./server", false},
+		{"a && b", false},
+		{"a || b", false},
+		{"a; b", false},
+		{"a | b", false},
+		{"( cd /tmp && ./server )", false},
+		{"{ ./server; }", false},
+		{"if true; then ./server; fi", false},
+		{"while true\ndo ./server\ndone", false},
+		{"cd /tmp", false},
+		{"exec ./server", false},
+		{"echo `date`", false},
+		{"", false},
+	}
+	for _, v := range tests {
+		expect := v.command
+		if v.exec {
+			expect = "exec " + expect
+		}
+		if cmd := shellCommand(v.command); cmd != expect {
+			t.Errorf("expecting %q for %q, got %q", expect, v.command, cmd)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -19,24 +21,87 @@ const help = `available commands are:
     start <service|all>   : starts a service or all services, in priority order
     stop <service|all>    : stops a service or all services, in priority order
     restart <service|all> : restart a service or all services, in priority order
+    signal [-g] <service|all> <signal>
+                          : send a signal (e.g. HUP or SIGUSR1) to a running
+                            service, or to its process group with -g
+    run [--name name] [--env K=V]... [--dir dir] [--user user] [--keep] -- cmd [args...]
+                          : run a transient service, not backed by a file,
+                            which is removed when stopped (or on exit with --keep)
+    enable <service>      : start the service when the daemon starts
+    disable <service>     : don't start the service when the daemon starts
     list                  : list registered services
+    status <service>      : show the status of a service and the last
+                            results of its watchdogs
+    check <service>       : run the watchdogs of a service immediately
+    diff                  : show the changes reload-config would apply,
+                            without applying them
+    reload-config         : rescan the services directory and apply any
+                            added, removed or changed services
+    events [-json] [service...]
+                          : stream service state changes, optionally
+                            filtered by service and JSON encoded
     exit                  : close the shell
-    help                  : show help`
+    help                  : show help
 
-func sendCommand(serverAddr string, args []string) (bool, error) {
+available from the command line only:
+    import [-o dir] [-f] <file...>
+                          : convert systemd .service units, supervisord
+                            [program:x] sections and Procfiles to service files
+    export systemd <service>
+                          : print a systemd unit for the service`
+
+// dialCommand connects to the daemon and sends the given command.
+func dialCommand(serverAddr string, args []string) (net.Conn, error) {
 	scheme, addr, err := parseServerAddr(serverAddr)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	conn, err := net.Dial(scheme, addr)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	defer conn.Close()
 	if err := encodeArgs(conn, args); err != nil {
-		return false, err
+		conn.Close()
+		return nil, err
 	}
 	log.Debugf("sent command %s", args)
+	return conn, nil
+}
+
+// queryCommand sends a command to the daemon and returns its
+// output instead of printing it. Error responses are returned
+// as an error.
+func queryCommand(serverAddr string, args []string) (string, error) {
+	conn, err := dialCommand(serverAddr, args)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	var buf bytes.Buffer
+	for {
+		r, s, err := decodeResponse(conn)
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case respEnd:
+			return buf.String(), nil
+		case respOk:
+			buf.WriteString(s)
+		case respErr:
+			return "", errors.New(strings.TrimSpace(s))
+		default:
+			return "", fmt.Errorf("invalid response type %d", r)
+		}
+	}
+}
+
+func sendCommand(serverAddr string, args []string) (bool, error) {
+	conn, err := dialCommand(serverAddr, args)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
 	closed := false
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Interrupt)
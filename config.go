@@ -7,42 +7,60 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
-	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
-	"gnd.la/config"
 	"gnd.la/log"
 
 	"github.com/fiam/stringutil"
 )
 
 type Config struct {
-	File             string
-	Command          string
-	Name             string
-	Dir              string
-	Env              map[string]string
-	Start            bool `default:"true"`
-	User             string
-	Group            string
-	Priority         int `default:"1000"`
-	Watchdog         *Watchdog
-	WatchdogInterval int `default:"300"`
-	MaxOpenFiles     int
-	Log              *Logger
-	Err              error
+	File             string            `yaml:"-" json:"-" toml:"-"`
+	Command          string            `yaml:"command" json:"command" toml:"command"`
+	Args             *Argv             `yaml:"args" json:"args" toml:"args"`
+	Shell            bool              `yaml:"shell" json:"shell" toml:"shell"`
+	Name             string            `yaml:"name" json:"name" toml:"name"`
+	Dir              string            `yaml:"dir" json:"dir" toml:"dir"`
+	Env              map[string]string `yaml:"env" json:"env" toml:"env"`
+	EnvironmentFile  string            `yaml:"environment_file" json:"environment_file" toml:"environment_file"`
+	CleanEnvironment bool              `yaml:"clean_environment" json:"clean_environment" toml:"clean_environment"`
+	PassEnvironment  string            `yaml:"pass_environment" json:"pass_environment" toml:"pass_environment"`
+	GoMaxProcs       string            `yaml:"go_max_procs" json:"go_max_procs" toml:"go_max_procs"`
+	Start            bool              `default:"true" yaml:"start" json:"start" toml:"start"`
+	User             string            `yaml:"user" json:"user" toml:"user"`
+	Group            string            `yaml:"group" json:"group" toml:"group"`
+	Priority         int               `default:"1000" yaml:"priority" json:"priority" toml:"priority"`
+	Watchdog         *Watchdog         `yaml:"watchdog" json:"watchdog" toml:"watchdog"`
+	Watchdogs        []*Watchdog       `yaml:"watchdogs" json:"watchdogs" toml:"watchdogs"`
+	OnFailure        *FailureActions   `yaml:"on_failure" json:"on_failure" toml:"on_failure"`
+	WaitHealthy      bool              `yaml:"wait_healthy" json:"wait_healthy" toml:"wait_healthy"`
+	WatchdogInterval int               `yaml:"watchdog_interval" json:"watchdog_interval" toml:"watchdog_interval"`
+	MaxOpenFiles     int               `yaml:"max_open_files" json:"max_open_files" toml:"max_open_files"`
+	StopTimeout      int               `yaml:"stop_timeout" json:"stop_timeout" toml:"stop_timeout"`
+	KillTimeout      int               `yaml:"kill_timeout" json:"kill_timeout" toml:"kill_timeout"`
+	Log              *Logger           `yaml:"log" json:"log" toml:"log"`
+	Notify           *Notifier         `yaml:"notify" json:"notify" toml:"notify"`
+	Err              error             `yaml:"-" json:"-" toml:"-"`
+	// transient services are created from the control
+	// socket and are not backed by a file
+	transient bool
+	// keep transient services registered after they're stopped
+	keep bool
 }
 
 func (c *Config) Cmd() (*exec.Cmd, error) {
 	if c.Err != nil {
 		return nil, c.Err
 	}
-	if c.Command == "" {
-		return nil, fmt.Errorf("no command")
+	env, err := c.environment()
+	if err != nil {
+		return nil, err
 	}
-	fields, err := stringutil.SplitFields(c.Command, " ")
+	fields, err := c.argv(envLookup(env))
 	if err != nil {
 		return nil, err
 	}
@@ -53,24 +71,18 @@ func (c *Config) Cmd() (*exec.Cmd, error) {
 		}
 		fields[0] = p
 	}
-	dir := c.Dir
+	dir := expandVars(c.Dir, envLookup(env))
 	if dir == "" {
 		dir = filepath.Dir(fields[0])
 	}
 	cmd := &exec.Cmd{Path: fields[0], Args: fields, Dir: dir}
-	for k, v := range c.Env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
-	}
-	if _, ok := c.Env["GOMAXPROCS"]; !ok {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("GOMAXPROCS=%d", runtime.NumCPU()))
-	}
-	for _, v := range os.Environ() {
-		if p := strings.IndexByte(v, '='); p >= 0 {
-			k := v[:p]
-			if _, ok := c.Env[k]; !ok {
-				cmd.Env = append(cmd.Env, v)
-			}
-		}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		cmd.Env = append(cmd.Env, k+"="+env[k])
 	}
 	info, err := os.Stat(fields[0])
 	if err != nil {
@@ -104,6 +116,9 @@ func (c *Config) Cmd() (*exec.Cmd, error) {
 	}
 	attr := &syscall.SysProcAttr{
 		Credential: cred,
+		// Put each service in its own process group, so
+		// it can be signaled as a whole.
+		Setpgid: true,
 	}
 	prepareSysProcAttr(attr)
 	cmd.SysProcAttr = attr
@@ -111,10 +126,74 @@ func (c *Config) Cmd() (*exec.Cmd, error) {
 	return cmd, nil
 }
 
+// argv returns the arguments for running the service, either from
+// Args (taken literally), from Command run by the shell if Shell is
+// enabled or from splitting Command on spaces. Variables in Command
+// are expanded using lookup.
+func (c *Config) argv(lookup func(string) (string, bool)) ([]string, error) {
+	if c.Args != nil && len(*c.Args) > 0 {
+		if c.Shell {
+			return nil, fmt.Errorf("Shell can't be used with Args")
+		}
+		return append([]string(nil), *c.Args...), nil
+	}
+	if c.Command == "" {
+		return nil, fmt.Errorf("no command")
+	}
+	command := expandVars(c.Command, lookup)
+	if c.Shell {
+		return []string{shellPath, "-c", shellCommand(command)}, nil
+	}
+	fields, err := stringutil.SplitFields(command, " ")
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no command")
+	}
+	return fields, nil
+}
+
+// watchdogs returns the configured watchdogs, Watchdog
+// followed by Watchdogs.
+func (c *Config) watchdogs() []*Watchdog {
+	var dogs []*Watchdog
+	if c.Watchdog != nil && c.Watchdog.dog != nil {
+		dogs = append(dogs, c.Watchdog)
+	}
+	for _, v := range c.Watchdogs {
+		if v != nil && v.dog != nil {
+			dogs = append(dogs, v)
+		}
+	}
+	return dogs
+}
+
+// stopTimeout returns the time to wait after sending SIGTERM
+// before killing the service.
+func (c *Config) stopTimeout() time.Duration {
+	if c.StopTimeout > 0 {
+		return time.Duration(c.StopTimeout) * time.Second
+	}
+	return defaultStopTimeout * time.Second
+}
+
+// killTimeout returns the time to wait for the service
+// to exit after killing it.
+func (c *Config) killTimeout() time.Duration {
+	if c.KillTimeout > 0 {
+		return time.Duration(c.KillTimeout) * time.Second
+	}
+	return defaultKillTimeout * time.Second
+}
+
 func (c *Config) ServiceName() string {
 	if c.Name != "" {
 		return c.Name
 	}
+	if ext := configFormatExt(c.File); ext != "" {
+		return c.File[:len(c.File)-len(ext)]
+	}
 	return c.File
 }
 
@@ -131,12 +210,19 @@ func (g *Governator) configDirIsDefault() bool {
 }
 
 func (g *Governator) parseConfig(filename string) *Config {
-	cfg := &Config{File: filename}
-	err := config.ParseFile(g.servicePath(filename), cfg)
-	cfg.Err = err
+	dcfg := g.currentDaemonConfig()
+	// Set before parsing, so the daemon default is only used when
+	// the file doesn't set watchdog_interval. A negative value still
+	// selects the built-in default.
+	cfg := &Config{File: filename, WatchdogInterval: dcfg.watchdogInterval()}
+	cfg.Err = parseConfigFile(g.servicePath(filename), cfg)
+	if enabled, ok := g.enabledOverride(filename); ok {
+		cfg.Start = enabled
+	}
+	dcfg.applyDefaults(cfg)
 	if cfg.Log == nil {
 		cfg.Log = new(Logger)
-		cfg.Log.Parse("")
+		cfg.Log.Parse(dcfg.Log)
 	}
 	cfg.Name = cfg.ServiceName()
 	cfg.Log.Name = cfg.Name
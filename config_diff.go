@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// configChange represents a changed field between
+// two configurations of the same service.
+type configChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+func (c *configChange) String() string {
+	return fmt.Sprintf("%s: %q -> %q", c.Field, c.Old, c.New)
+}
+
+// configFieldValue returns a comparable representation of a
+// configuration field. Watchdogs, loggers and notifiers are
+// represented by the string they were parsed from, since they
+// hold state while the service is running.
+func configFieldValue(v reflect.Value) string {
+	if !v.IsValid() {
+		return ""
+	}
+	switch x := v.Interface().(type) {
+	case *Watchdog:
+		if x == nil {
+			return ""
+		}
+		return x.input
+	case []*Watchdog:
+		inputs := make([]string, len(x))
+		for ii, v := range x {
+			if v != nil {
+				inputs[ii] = v.input
+			}
+		}
+		return strings.Join(inputs, ", ")
+	case *Logger:
+		if x == nil {
+			return ""
+		}
+		return x.input
+	case *Notifier:
+		if x == nil {
+			return ""
+		}
+		return x.input
+	case *FailureActions:
+		if x == nil {
+			return ""
+		}
+		return x.input
+	case error:
+		if x == nil {
+			return ""
+		}
+		return x.Error()
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return ""
+		}
+		return configFieldValue(v.Elem())
+	case reflect.Map:
+		values := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			values = append(values, fmt.Sprintf("%v=%v", k.Interface(), v.MapIndex(k).Interface()))
+		}
+		sort.Strings(values)
+		return strings.Join(values, " ")
+	case reflect.Slice:
+		if v.Len() == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%q", v.Interface())
+	}
+	return fmt.Sprint(v.Interface())
+}
+
+// diffConfigs returns the fields which differ between the
+// old and new configurations. File is not compared, since
+// configurations are matched by file.
+func diffConfigs(old *Config, cfg *Config) []*configChange {
+	var changes []*configChange
+	ov := reflect.ValueOf(old).Elem()
+	nv := reflect.ValueOf(cfg).Elem()
+	typ := ov.Type()
+	for ii := 0; ii < typ.NumField(); ii++ {
+		field := typ.Field(ii)
+		if field.PkgPath != "" || field.Name == "File" {
+			continue
+		}
+		o := configFieldValue(ov.Field(ii))
+		n := configFieldValue(nv.Field(ii))
+		if o != n {
+			changes = append(changes, &configChange{Field: field.Name, Old: o, New: n})
+		}
+	}
+	return changes
+}
+
+// serviceDiff describes what would happen to a
+// service if the configuration was reloaded.
+type serviceDiff struct {
+	Name    string
+	Added   bool
+	Removed bool
+	Changes []*configChange
+	// ParseErr is set when the configuration file can't be
+	// parsed, so the current configuration would be kept.
+	ParseErr error
+	// Running is true if the service is currently
+	// running (for changed or removed services) or
+	// would be started (for added ones).
+	Running bool
+}
+
+func (d *serviceDiff) String() string {
+	var buf bytes.Buffer
+	switch {
+	case d.Added:
+		if d.Running {
+			fmt.Fprintf(&buf, "%s: would be added and started\n", d.Name)
+		} else {
+			fmt.Fprintf(&buf, "%s: would be added\n", d.Name)
+		}
+	case d.ParseErr != nil:
+		fmt.Fprintf(&buf, "%s: parse error, keeping current config: %s\n", d.Name, d.ParseErr)
+	case d.Removed:
+		if d.Running {
+			fmt.Fprintf(&buf, "%s: would be stopped and removed\n", d.Name)
+		} else {
+			fmt.Fprintf(&buf, "%s: would be removed\n", d.Name)
+		}
+	default:
+		if d.Running {
+			fmt.Fprintf(&buf, "%s: would be restarted\n", d.Name)
+		} else {
+			fmt.Fprintf(&buf, "%s: would be updated\n", d.Name)
+		}
+		for _, v := range d.Changes {
+			fmt.Fprintf(&buf, "    %s\n", v)
+		}
+	}
+	return buf.String()
+}
+
+// DiffConfig compares the configuration files with the
+// configuration of the registered services and returns
+// the differences, without applying any of them.
+func (g *Governator) DiffConfig() ([]*serviceDiff, error) {
+	configs, err := g.parseConfigs()
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.diffLocked(configs), nil
+}
+
+// diffLocked compares the given configurations with the
+// configuration of the registered services.
+func (g *Governator) diffLocked(configs []*Config) []*serviceDiff {
+	var diffs []*serviceDiff
+	files := make(map[string]bool)
+	for _, v := range configs {
+		files[v.File] = true
+		_, s := g.serviceByFilenameLocked(v.File)
+		if s == nil {
+			diffs = append(diffs, &serviceDiff{Name: v.ServiceName(), Added: true, Running: v.Start})
+			continue
+		}
+		if v.Err != nil && s.Config.Err == nil {
+			diffs = append(diffs, &serviceDiff{Name: s.Name(), ParseErr: v.Err})
+			continue
+		}
+		g.ensureUniqueName(v, s)
+		if changes := diffConfigs(s.Config, v); len(changes) > 0 {
+			diffs = append(diffs, &serviceDiff{Name: s.Name(), Changes: changes, Running: s.State == StateStarted})
+		}
+	}
+	for _, v := range g.services {
+		if !v.Config.transient && !files[v.Config.File] {
+			diffs = append(diffs, &serviceDiff{Name: v.Name(), Removed: true, Running: v.State.canStop()})
+		}
+	}
+	return diffs
+}
+
+// listedService is a service as shown by the list command.
+type listedService struct {
+	Name      string
+	Transient bool
+	State     State
+}
+
+// parseServiceList parses the output of the list command.
+func parseServiceList(out string) []*listedService {
+	var services []*listedService
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[0] == "SERVICE" {
+			continue
+		}
+		ls := &listedService{Name: fields[0], Transient: fields[1] == "transient"}
+		switch fields[2] {
+		case "STOPPING":
+			ls.State = StateStopping
+		case "STARTING":
+			ls.State = StateStarting
+		case "RUNNING", "UNHEALTHY":
+			ls.State = StateStarted
+		case "BACKOFF":
+			ls.State = StateBackoff
+		case "FAILED":
+			ls.State = StateFailed
+		default:
+			ls.State = StateStopped
+		}
+		services = append(services, ls)
+	}
+	return services
+}
+
+// DiffRunning compares the configuration files with the services
+// of the daemon at addr, which might use another configuration
+// directory. The daemon's services are obtained from its list
+// command and their configurations are parsed from the directory
+// reported by its conf command.
+func (g *Governator) DiffRunning(addr string) ([]*serviceDiff, error) {
+	configs, err := g.parseConfigs()
+	if err != nil {
+		return nil, err
+	}
+	out, err := queryCommand(addr, []string{"list"})
+	if err != nil {
+		return nil, fmt.Errorf("error listing services: %s", err)
+	}
+	dir, err := queryCommand(addr, []string{"conf", "config-dir"})
+	if err != nil {
+		return nil, fmt.Errorf("error querying configuration directory: %s", err)
+	}
+	running, err := NewGovernator(strings.TrimSpace(dir))
+	if err != nil {
+		return nil, err
+	}
+	runningConfigs, err := running.parseConfigs()
+	if err != nil {
+		return nil, err
+	}
+	running.mu.Lock()
+	defer running.mu.Unlock()
+	for _, v := range runningConfigs {
+		running.addServiceLocked(v)
+	}
+	var services []*Service
+	for _, v := range parseServiceList(out) {
+		if v.Transient {
+			continue
+		}
+		s, _ := running.serviceByNameLocked(v.Name)
+		if s == nil {
+			// Its file has been removed since the daemon loaded it
+			s = newService(&Config{Name: v.Name})
+		}
+		s.State = v.State
+		services = append(services, s)
+	}
+	// Services not listed by the daemon haven't been loaded yet
+	running.services = services
+	return running.diffLocked(configs), nil
+}
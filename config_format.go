@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gnd.la/config"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// service file extensions which select a structured format,
+	// any other file is parsed with gnd.la/config
+	configFormats = map[string]func([]byte, *Config) error{
+		".yaml": decodeYAMLConfig,
+		".yml":  decodeYAMLConfig,
+		".toml": decodeTOMLConfig,
+		".json": decodeJSONConfig,
+	}
+	jsonUnknownFieldRe = regexp.MustCompile(`^json: unknown field "(.*)"$`)
+)
+
+// configFormatExt returns the extension of the given file if it
+// selects a structured format, or an empty string otherwise.
+func configFormatExt(filename string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if _, ok := configFormats[ext]; ok {
+		return ext
+	}
+	return ""
+}
+
+// parseConfigFile decodes the service file at path into cfg, using
+// the format determined by its extension. In YAML, JSON and TOML
+// files, the settings which use the same syntax as in gnd.la/config
+// (watchdogs, failure actions, loggers and notifiers) are strings
+// decoded by their UnmarshalText method, which all three decoders
+// use for types implementing encoding.TextUnmarshaler.
+func parseConfigFile(path string, cfg *Config) error {
+	decode := configFormats[configFormatExt(path)]
+	if decode == nil {
+		return config.ParseFile(path, cfg)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := setDefaults(cfg); err != nil {
+		return err
+	}
+	return decode(data, cfg)
+}
+
+// setDefaults sets the fields with a default tag to their
+// default value, since only gnd.la/config honors them.
+func setDefaults(v interface{}) error {
+	val := reflect.ValueOf(v).Elem()
+	typ := val.Type()
+	for ii := 0; ii < typ.NumField(); ii++ {
+		def := typ.Field(ii).Tag.Get("default")
+		if def == "" {
+			continue
+		}
+		f := val.Field(ii)
+		switch f.Kind() {
+		case reflect.Bool:
+			b, err := strconv.ParseBool(def)
+			if err != nil {
+				return err
+			}
+			f.SetBool(b)
+		case reflect.Int:
+			n, err := strconv.ParseInt(def, 10, 64)
+			if err != nil {
+				return err
+			}
+			f.SetInt(n)
+		case reflect.String:
+			f.SetString(def)
+		default:
+			return fmt.Errorf("can't set default value for field %s of type %s", typ.Field(ii).Name, f.Type())
+		}
+	}
+	return nil
+}
+
+// lineAt returns the 1-based line number for the given offset in data.
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte{'\n'}) + 1
+}
+
+// keyLine returns the line where the given key is assigned, looking
+// only after the start line, or 0 if it can't be found.
+func keyLine(data []byte, start int, key string, sep string) int {
+	re := regexp.MustCompile(`^\s*["']?` + regexp.QuoteMeta(key) + `["']?\s*` + sep)
+	for ii, v := range strings.Split(string(data), "\n") {
+		if ii+1 >= start && re.MatchString(v) {
+			return ii + 1
+		}
+	}
+	return 0
+}
+
+func decodeYAMLConfig(data []byte, cfg *Config) error {
+	// yaml.v2 already includes line numbers for unknown keys
+	return yaml.UnmarshalStrict(data, cfg)
+}
+
+func decodeTOMLConfig(data []byte, cfg *Config) error {
+	md, err := toml.Decode(string(data), cfg)
+	if err != nil {
+		return err
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		key := undecoded[0]
+		start := 1
+		if len(key) > 1 {
+			// Look for the key after its table header
+			table := regexp.MustCompile(`^\s*\[+\s*` + regexp.QuoteMeta(strings.Join(key[:len(key)-1], ".")) + `\s*\]+`)
+			for ii, v := range strings.Split(string(data), "\n") {
+				if table.MatchString(v) {
+					start = ii + 1
+					break
+				}
+			}
+		}
+		if line := keyLine(data, start, key[len(key)-1], "="); line > 0 {
+			return fmt.Errorf("line %d: unknown key %q", line, key.String())
+		}
+		return fmt.Errorf("unknown key %q", key.String())
+	}
+	return nil
+}
+
+func decodeJSONConfig(data []byte, cfg *Config) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(cfg)
+	switch x := err.(type) {
+	case nil:
+	case *json.SyntaxError:
+		return fmt.Errorf("line %d: %s", lineAt(data, x.Offset), err)
+	case *json.UnmarshalTypeError:
+		return fmt.Errorf("line %d: %s", lineAt(data, x.Offset), err)
+	default:
+		if m := jsonUnknownFieldRe.FindStringSubmatch(err.Error()); m != nil {
+			if line := keyLine(data, 1, m[1], ":"); line > 0 {
+				return fmt.Errorf("line %d: unknown key %q", line, m[1])
+			}
+			return fmt.Errorf("unknown key %q", m[1])
+		}
+	}
+	return err
+}
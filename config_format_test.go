@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var configFormatTests = map[string]string{
+	"plain": `command = sleep 1
+watchdog = connect tcp://localhost:80
+on_failure = signal HUP; restart
+notify = command true
+priority = 10
+`,
+	"svc.yaml": `command: sleep 1
+watchdog: connect tcp://localhost:80
+on_failure: signal HUP; restart
+notify: command true
+priority: 10
+`,
+	"svc.toml": `command = "sleep 1"
+watchdog = "connect tcp://localhost:80"
+on_failure = "signal HUP; restart"
+notify = "command true"
+priority = 10
+`,
+	"svc.json": `{
+	"command": "sleep 1",
+	"watchdog": "connect tcp://localhost:80",
+	"on_failure": "signal HUP; restart",
+	"notify": "command true",
+	"priority": 10
+}
+`,
+}
+
+var configFormatErrorTests = map[string]string{
+	"bad.yaml": "command: sleep 1\n\nfoo: bar\n",
+	"bad.toml": "command = \"sleep 1\"\n\nfoo = \"bar\"\n",
+	"bad.json": "{\n\t\"command\": \"sleep 1\",\n\t\"foo\": \"bar\"\n}\n",
+}
+
+func TestConfigFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "governator-formats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "services"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGovernator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range configFormatTests {
+		writeServiceFile(t, g, k, v)
+	}
+	for k, v := range configFormatErrorTests {
+		writeServiceFile(t, g, k, v)
+	}
+	expect := g.parseConfig("plain")
+	if expect.Err != nil {
+		t.Fatal(expect.Err)
+	}
+	for k := range configFormatTests {
+		cfg := g.parseConfig(k)
+		if cfg.Err != nil {
+			t.Errorf("error parsing %s: %s", k, cfg.Err)
+			continue
+		}
+		if name := strings.TrimSuffix(k, filepath.Ext(k)); cfg.Name != name {
+			t.Errorf("expecting name %q for %s, got %q", name, k, cfg.Name)
+		}
+		if !cfg.Start || cfg.CleanEnvironment {
+			t.Errorf("defaults not applied to %s", k)
+		}
+		if cfg.Watchdog == nil || cfg.OnFailure == nil || cfg.Notify == nil {
+			t.Errorf("string fields not decoded in %s", k)
+			continue
+		}
+		if s := cfg.OnFailure.String(); s != "signal HUP; restart" {
+			t.Errorf("expecting on_failure = %q in %s, got %q", "signal HUP; restart", k, s)
+		}
+		if changes := diffConfigs(expect, cfg); len(changes) > 1 || (len(changes) == 1 && changes[0].Field != "Name") {
+			t.Errorf("%s differs from plain config: %v", k, changes)
+		}
+	}
+	for k := range configFormatErrorTests {
+		cfg := g.parseConfig(k)
+		if cfg.Err == nil {
+			t.Errorf("expecting an error parsing %s", k)
+			continue
+		}
+		if msg := cfg.Err.Error(); !strings.Contains(msg, "line 3") || !strings.Contains(msg, "foo") {
+			t.Errorf("expecting an error at line 3 mentioning foo for %s, got %q", k, msg)
+		}
+	}
+}
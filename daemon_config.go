@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gnd.la/config"
+	"gnd.la/log"
+)
+
+const (
+	defaultStopTimeout = 10
+	defaultKillTimeout = 2
+	defaultTierTimeout = 60
+)
+
+// daemonConfig holds the settings read from governator.conf,
+// which lives next to the services directory. The first group
+// of fields affects the daemon as a whole, while the second one
+// provides defaults for the services which don't set them.
+type daemonConfig struct {
+	// Daemon URL, unix:///path/to/socket or tcp://host:port
+	Socket string
+	// Group which owns the daemon socket, defaults to governator
+	SocketGroup string
+	// Address to serve metrics at, disabled by default
+	Metrics string
+	// Directory for service logs
+	LogDir string
+	// Seconds between configuration reconciliations. 0 means the
+	// default interval while a negative value disables it.
+	ReconcileInterval int
+	// Seconds to wait for the services with WaitHealthy in a
+	// priority tier before starting the next one
+	TierTimeout int
+	// Global notifier, receives events for all services
+	Notify *Notifier
+
+	// Service defaults
+	User             string
+	Group            string
+	Env              map[string]string
+	MaxOpenFiles     int
+	Log              string
+	WatchdogInterval int
+	StopTimeout      int
+	KillTimeout      int
+}
+
+// applyDefaults sets the fields not set in the service
+// configuration to the values from the daemon configuration.
+// Environment variables are merged, giving precedence to the
+// ones in the service configuration.
+func (d *daemonConfig) applyDefaults(cfg *Config) {
+	if cfg.User == "" {
+		cfg.User = d.User
+	}
+	if cfg.Group == "" {
+		cfg.Group = d.Group
+	}
+	if len(d.Env) > 0 {
+		env := make(map[string]string, len(d.Env)+len(cfg.Env))
+		for k, v := range d.Env {
+			env[k] = v
+		}
+		for k, v := range cfg.Env {
+			env[k] = v
+		}
+		cfg.Env = env
+	}
+	if cfg.MaxOpenFiles == 0 {
+		cfg.MaxOpenFiles = d.MaxOpenFiles
+	}
+	if cfg.StopTimeout == 0 {
+		cfg.StopTimeout = d.StopTimeout
+	}
+	if cfg.KillTimeout == 0 {
+		cfg.KillTimeout = d.KillTimeout
+	}
+}
+
+func (d *daemonConfig) reconcileInterval() time.Duration {
+	switch {
+	case d.ReconcileInterval < 0:
+		return 0
+	case d.ReconcileInterval == 0:
+		return defaultReconcileInterval
+	}
+	return time.Duration(d.ReconcileInterval) * time.Second
+}
+
+// watchdogInterval returns the interval for the services
+// which don't set watchdog_interval.
+func (d *daemonConfig) watchdogInterval() int {
+	if d.WatchdogInterval > 0 {
+		return d.WatchdogInterval
+	}
+	return defaultWatchdogInterval
+}
+
+func (d *daemonConfig) tierTimeout() time.Duration {
+	if d.TierTimeout > 0 {
+		return time.Duration(d.TierTimeout) * time.Second
+	}
+	return defaultTierTimeout * time.Second
+}
+
+func (d *daemonConfig) socketGroup() string {
+	if d.SocketGroup != "" {
+		return d.SocketGroup
+	}
+	return AppName
+}
+
+func (d *daemonConfig) validate() error {
+	if d.Socket != "" {
+		if _, _, err := parseServerAddr(d.Socket); err != nil {
+			return err
+		}
+	}
+	if d.SocketGroup != "" && getGroupId(d.SocketGroup) < 0 {
+		return fmt.Errorf("invalid socket group %q", d.SocketGroup)
+	}
+	if d.Log != "" {
+		if err := new(Logger).Parse(d.Log); err != nil {
+			return fmt.Errorf("invalid default logger: %s", err)
+		}
+	}
+	if d.WatchdogInterval < 0 {
+		return fmt.Errorf("invalid watchdog interval %d", d.WatchdogInterval)
+	}
+	if d.TierTimeout < 0 {
+		return fmt.Errorf("invalid tier timeout %d", d.TierTimeout)
+	}
+	if d.StopTimeout < 0 || d.KillTimeout < 0 {
+		return fmt.Errorf("stop and kill timeouts can't be negative")
+	}
+	return nil
+}
+
+func daemonConfigPath(configDir string) string {
+	return filepath.Join(configDir, AppName+".conf")
+}
+
+// parseDaemonConfig parses and validates the daemon configuration
+// file in the given configuration directory. A missing file is not
+// an error.
+func parseDaemonConfig(configDir string) (*daemonConfig, error) {
+	cfg := &daemonConfig{}
+	if configDir == "" {
+		return cfg, nil
+	}
+	p := daemonConfigPath(configDir)
+	if _, err := os.Stat(p); err != nil && os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err := config.ParseFile(p, cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (g *Governator) daemonConfigPath() string {
+	return daemonConfigPath(g.configDir)
+}
+
+func (g *Governator) currentDaemonConfig() *daemonConfig {
+	g.dmu.Lock()
+	defer g.dmu.Unlock()
+	return g.daemonConfig
+}
+
+func (g *Governator) setDaemonConfig(cfg *daemonConfig) {
+	if cfg.LogDir != "" {
+		setLogDir(cfg.LogDir)
+	}
+	g.dmu.Lock()
+	defer g.dmu.Unlock()
+	g.daemonConfig = cfg
+}
+
+// ReloadDaemonConfig parses the daemon configuration again and
+// applies it. Changes to the service defaults are applied on the
+// next ReloadConfig, while changes to the socket, the metrics
+// address and the reconcile interval require restarting the
+// daemon. If the file has errors, the current configuration
+// is kept.
+func (g *Governator) ReloadDaemonConfig() error {
+	cfg, err := parseDaemonConfig(g.configDir)
+	if err != nil {
+		return fmt.Errorf("error parsing %s: %s", g.daemonConfigPath(), err)
+	}
+	prev := g.currentDaemonConfig()
+	if cfg.Socket != prev.Socket || cfg.SocketGroup != prev.SocketGroup ||
+		cfg.Metrics != prev.Metrics || cfg.ReconcileInterval != prev.ReconcileInterval {
+		log.Infof("changes to Socket, SocketGroup, Metrics and ReconcileInterval in %s require restarting the daemon", g.daemonConfigPath())
+	}
+	g.setDaemonConfig(cfg)
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/fiam/stringutil"
+)
+
+const (
+	// PATH for services which don't inherit the daemon environment
+	defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+)
+
+// expandVars replaces ${VAR} and ${VAR:-default} in s with the
+// values returned by lookup. In the latter form, default is used
+// when the variable is unset or empty. Any other $ is left as is.
+func expandVars(s string, lookup func(string) (string, bool)) string {
+	var buf []byte
+	rem := s
+	expanded := false
+	for {
+		start := strings.Index(rem, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(rem[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+		expanded = true
+		buf = append(buf, rem[:start]...)
+		expr := rem[start+2 : end]
+		name := expr
+		var def string
+		hasDef := false
+		if p := strings.Index(expr, ":-"); p >= 0 {
+			name = expr[:p]
+			def = expr[p+2:]
+			hasDef = true
+		}
+		value, ok := lookup(name)
+		if hasDef && (!ok || value == "") {
+			value = def
+		}
+		buf = append(buf, value...)
+		rem = rem[end+1:]
+	}
+	if !expanded {
+		return s
+	}
+	return string(append(buf, rem...))
+}
+
+// parseEnvFile parses a file in dotenv format, which contains
+// KEY=VALUE lines, optionally prefixed by export. Empty lines
+// and lines starting with # are ignored. Values might be
+// enclosed in single quotes (taken literally) or double quotes
+// (which interpret escape sequences like \n).
+func parseEnvFile(name string) (map[string]string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	env := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || s[0] == '#' {
+			continue
+		}
+		s = strings.TrimPrefix(s, "export ")
+		p := strings.IndexByte(s, '=')
+		if p <= 0 {
+			return nil, fmt.Errorf("%s:%d: invalid line, must be KEY=VALUE", name, line)
+		}
+		key := strings.TrimSpace(s[:p])
+		value := strings.TrimSpace(s[p+1:])
+		if len(value) >= 2 {
+			switch {
+			case value[0] == '\'' && value[len(value)-1] == '\'':
+				value = value[1 : len(value)-1]
+			case value[0] == '"' && value[len(value)-1] == '"':
+				v, err := strconv.Unquote(value)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: invalid quoted value %s", name, line, value)
+				}
+				value = v
+			}
+		}
+		env[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// environmentFiles returns the environment files for the service,
+// indicating for each one if it's optional (prefixed by -).
+func (c *Config) environmentFiles() ([]string, []bool, error) {
+	if c.EnvironmentFile == "" {
+		return nil, nil, nil
+	}
+	fields, err := stringutil.SplitFields(c.EnvironmentFile, " ")
+	if err != nil {
+		return nil, nil, err
+	}
+	optional := make([]bool, len(fields))
+	for ii, v := range fields {
+		if strings.HasPrefix(v, "-") {
+			fields[ii] = v[1:]
+			optional[ii] = true
+		}
+	}
+	return fields, optional, nil
+}
+
+// environment returns the environment variables for the service.
+// They're built from the daemon environment (all of it or, with
+// CleanEnvironment, just the variables in PassEnvironment), then the environment files in order and
+// finally Env, whose values might reference the variables defined
+// by the previous sources. GOMAXPROCS is set according to the
+// GoMaxProcs setting, unless the service sets it explicitly.
+func (c *Config) environment() (map[string]string, error) {
+	env := make(map[string]string)
+	var pass map[string]bool
+	if c.CleanEnvironment {
+		names, err := stringutil.SplitFields(c.PassEnvironment, " ,")
+		if err != nil {
+			return nil, err
+		}
+		pass = make(map[string]bool, len(names))
+		for _, v := range names {
+			pass[v] = true
+		}
+	}
+	for _, v := range os.Environ() {
+		if p := strings.IndexByte(v, '='); p >= 0 {
+			if k := v[:p]; pass == nil || pass[k] {
+				env[k] = v[p+1:]
+			}
+		}
+	}
+	if _, ok := env["PATH"]; !ok && pass != nil {
+		env["PATH"] = defaultPath
+	}
+	configured := make(map[string]string)
+	files, optional, err := c.environmentFiles()
+	if err != nil {
+		return nil, err
+	}
+	for ii, v := range files {
+		fenv, err := parseEnvFile(v)
+		if err != nil {
+			if optional[ii] && os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("error reading environment file: %s", err)
+		}
+		for k, v := range fenv {
+			env[k] = v
+			configured[k] = v
+		}
+	}
+	lookup := envLookup(env)
+	expanded := make(map[string]string, len(c.Env))
+	for k, v := range c.Env {
+		expanded[k] = expandVars(v, lookup)
+	}
+	for k, v := range expanded {
+		env[k] = v
+		configured[k] = v
+	}
+	if _, ok := configured["GOMAXPROCS"]; !ok {
+		procs, err := c.goMaxProcs()
+		if err != nil {
+			return nil, err
+		}
+		if procs > 0 {
+			env["GOMAXPROCS"] = strconv.Itoa(procs)
+		}
+	}
+	return env, nil
+}
+
+// goMaxProcs returns the value for GOMAXPROCS in the service
+// environment, or 0 if it shouldn't be set. GoMaxProcs might be:
+//
+//	auto (or empty): the number of CPUs
+//	cgroup: the CPU quota of the daemon cgroup, or the number of CPUs without a quota
+//	off: don't set GOMAXPROCS
+//	a positive integer
+func (c *Config) goMaxProcs() (int, error) {
+	switch strings.ToLower(c.GoMaxProcs) {
+	case "", "auto":
+		return runtime.NumCPU(), nil
+	case "off", "none":
+		return 0, nil
+	case "cgroup":
+		if n := cgroupCPUQuota(); n > 0 {
+			return n, nil
+		}
+		return runtime.NumCPU(), nil
+	}
+	n, err := strconv.Atoi(c.GoMaxProcs)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid GoMaxProcs %q - must be auto, cgroup, off or a positive integer", c.GoMaxProcs)
+	}
+	return n, nil
+}
+
+// cgroupCPUQuota returns the number of CPUs allowed by the
+// CFS quota of the cgroup the daemon belongs to, rounded up,
+// or 0 if there's no quota. Both cgroup v1 and v2 are supported.
+func cgroupCPUQuota() int {
+	var quota, period float64
+	if data, err := ioutil.ReadFile(filepath.Join(cgroupV2Dir(), "cpu.max")); err == nil {
+		// v2: "$MAX $PERIOD", where $MAX might be "max"
+		fields := strings.Fields(string(data))
+		if len(fields) != 2 || fields[0] == "max" {
+			return 0
+		}
+		quota, _ = strconv.ParseFloat(fields[0], 64)
+		period, _ = strconv.ParseFloat(fields[1], 64)
+	} else {
+		q, err1 := ioutil.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_quota_us")
+		p, err2 := ioutil.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_period_us")
+		if err1 != nil || err2 != nil {
+			return 0
+		}
+		quota, _ = strconv.ParseFloat(strings.TrimSpace(string(q)), 64)
+		period, _ = strconv.ParseFloat(strings.TrimSpace(string(p)), 64)
+	}
+	if quota <= 0 || period <= 0 {
+		return 0
+	}
+	return int(math.Ceil(quota / period))
+}
+
+// cgroupV2Dir returns the directory for the cgroup v2
+// the daemon belongs to.
+func cgroupV2Dir() string {
+	const root = "/sys/fs/cgroup"
+	data, err := ioutil.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return root
+	}
+	for _, v := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(v, "0::") {
+			return filepath.Join(root, v[3:])
+		}
+	}
+	return root
+}
+
+func envLookup(env map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}
+}
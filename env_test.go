@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestExpandVars(t *testing.T) {
+	env := map[string]string{
+		"HOST":  "example.com",
+		"PORT":  "8080",
+		"EMPTY": "",
+	}
+	tests := []struct {
+		input  string
+		output string
+	}{
+		{"no vars", "no vars"},
+		{"${HOST}:${PORT}", "example.com:8080"},
+		{"${MISSING}", ""},
+		{"${MISSING:-default}", "default"},
+		{"${EMPTY:-default}", "default"},
+		{"${PORT:-80}", "8080"},
+		{"$HOST ${HOST", "$HOST ${HOST"},
+	}
+	for _, v := range tests {
+		if out := expandVars(v.input, envLookup(env)); out != v.output {
+			t.Errorf("expanding %q: expecting %q, got %q", v.input, v.output, out)
+		}
+	}
+}
+
+func TestEnvironmentFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "envfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# comment\n\nexport A=1\nB='${A} literal'\nC=\"quoted\\tvalue\"\n")
+	f.Close()
+	env, err := parseEnvFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{"A": "1", "B": "${A} literal", "C": "quoted\tvalue"}
+	if !reflect.DeepEqual(env, expect) {
+		t.Errorf("expecting environment %v, got %v", expect, env)
+	}
+	cfg := &Config{
+		EnvironmentFile: f.Name() + " -/does/not/exist",
+		Env:             map[string]string{"D": "${A:-0}${E:-2}"},
+	}
+	cenv, err := cfg.environment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cenv["A"] != "1" || cenv["D"] != "12" {
+		t.Errorf("unexpected environment A=%q D=%q", cenv["A"], cenv["D"])
+	}
+	cfg.EnvironmentFile = "/does/not/exist"
+	if _, err := cfg.environment(); err == nil {
+		t.Error("expecting an error with a missing environment file")
+	}
+}
+
+func TestCleanEnvironment(t *testing.T) {
+	os.Setenv("GOVERNATOR_TEST_PASS", "pass")
+	os.Setenv("GOVERNATOR_TEST_LEAK", "leak")
+	defer os.Unsetenv("GOVERNATOR_TEST_PASS")
+	defer os.Unsetenv("GOVERNATOR_TEST_LEAK")
+	// The zero value inherits the daemon environment
+	env, err := new(Config).environment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["GOVERNATOR_TEST_LEAK"] != "leak" {
+		t.Error("environment was not inherited by default")
+	}
+	cfg := &Config{
+		CleanEnvironment: true,
+		PassEnvironment:  "GOVERNATOR_TEST_PASS",
+		GoMaxProcs:       "off",
+	}
+	env, err = cfg.environment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["GOVERNATOR_TEST_PASS"] != "pass" {
+		t.Error("allowed variable was not passed")
+	}
+	if _, ok := env["GOVERNATOR_TEST_LEAK"]; ok {
+		t.Error("variable leaked into a clean environment")
+	}
+	if env["PATH"] == "" {
+		t.Error("clean environment has no PATH")
+	}
+	if _, ok := env["GOMAXPROCS"]; ok {
+		t.Error("GOMAXPROCS was set with GoMaxProcs = off")
+	}
+	cfg.CleanEnvironment = false
+	cfg.GoMaxProcs = "3"
+	env, err = cfg.environment()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env["GOVERNATOR_TEST_LEAK"] != "leak" || env["GOMAXPROCS"] != "3" {
+		t.Errorf("unexpected environment LEAK=%q GOMAXPROCS=%q", env["GOVERNATOR_TEST_LEAK"], env["GOMAXPROCS"])
+	}
+	cfg.GoMaxProcs = "lots"
+	if _, err := cfg.environment(); err == nil {
+		t.Error("expecting an error with an invalid GoMaxProcs")
+	}
+}
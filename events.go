@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// events are dropped for subscribers which
+	// fall behind by more than this number
+	eventsBufferSize = 64
+)
+
+type EventType uint8
+
+const (
+	EventStarting EventType = iota + 1
+	EventStarted
+	EventExited
+	EventBackoff
+	EventFailed
+	EventStopping
+	EventStopped
+	EventWatchdogFailed
+)
+
+var eventTypeNames = map[EventType]string{
+	EventStarting:       "starting",
+	EventStarted:        "started",
+	EventExited:         "exited",
+	EventBackoff:        "backoff",
+	EventFailed:         "failed",
+	EventStopping:       "stopping",
+	EventStopped:        "stopped",
+	EventWatchdogFailed: "watchdog-failed",
+}
+
+func (t EventType) String() string {
+	if s, ok := eventTypeNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+func (t EventType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+func (t *EventType) UnmarshalText(b []byte) error {
+	s := strings.ToLower(string(b))
+	for k, v := range eventTypeNames {
+		if v == s {
+			*t = k
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid event type %q", string(b))
+}
+
+// Event represents a state transition in a service.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Service string    `json:"service"`
+	Type    EventType `json:"type"`
+	State   State     `json:"state"`
+	Err     string    `json:"error,omitempty"`
+}
+
+func (e *Event) String() string {
+	s := fmt.Sprintf("%s %s %s", formatTime(e.Time), e.Service, e.Type)
+	if e.Err != "" {
+		s += " - " + e.Err
+	}
+	return s
+}
+
+func (e *Event) JSON() string {
+	data, err := json.Marshal(e)
+	if err != nil {
+		panic(err)
+	}
+	return string(data)
+}
+
+type eventSubscription struct {
+	services []string
+	ch       chan *Event
+}
+
+func (s *eventSubscription) matches(ev *Event) bool {
+	if len(s.services) == 0 {
+		return true
+	}
+	for _, v := range s.services {
+		if v == ev.Service {
+			return true
+		}
+	}
+	return false
+}
+
+type eventBus struct {
+	mu   sync.Mutex
+	subs []*eventSubscription
+}
+
+func newEventBus() *eventBus {
+	return &eventBus{}
+}
+
+// Subscribe returns a channel which receives the events for
+// the given services (or all of them, if no services are provided)
+// and a function which must be called to cancel the subscription.
+func (b *eventBus) Subscribe(services ...string) (<-chan *Event, func()) {
+	sub := &eventSubscription{
+		services: services,
+		ch:       make(chan *Event, eventsBufferSize),
+	}
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+	return sub.ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		for ii, v := range b.subs {
+			if v == sub {
+				b.subs = append(b.subs[:ii], b.subs[ii+1:]...)
+				close(sub.ch)
+				break
+			}
+		}
+	}
+}
+
+// Publish sends the event to all the matching subscribers. It
+// never blocks, so it's safe to call it with the service lock held.
+func (b *eventBus) Publish(ev *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, v := range b.subs {
+		if v.matches(ev) {
+			select {
+			case v.ch <- ev:
+			default:
+			}
+		}
+	}
+}
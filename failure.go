@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fiam/stringutil"
+)
+
+const (
+	failureRestart   = "restart"
+	failureSignal    = "signal"
+	failureRun       = "run"
+	failureUnhealthy = "unhealthy"
+	failureStop      = "stop"
+)
+
+type failureAction struct {
+	name string
+	sig  syscall.Signal
+	argv []string
+}
+
+func (a *failureAction) String() string {
+	switch a.name {
+	case failureSignal:
+		return fmt.Sprintf("%s %s", a.name, a.sig)
+	case failureRun:
+		return fmt.Sprintf("%s %s", a.name, a.argv)
+	}
+	return a.name
+}
+
+// FailureActions are executed in order when a watchdog reaches its
+// failure threshold. Actions are separated by semicolons:
+//
+//	signal SIGQUIT; run /usr/local/bin/report; restart
+//
+// Available actions are restart, signal <signal>, run <cmd> [args...],
+// unhealthy (mark the service as unhealthy, without restarting it) and
+// stop. When no actions are configured, the service is restarted.
+type FailureActions struct {
+	input   string
+	actions []*failureAction
+}
+
+// UnmarshalText parses the failure actions using Parse.
+func (f *FailureActions) UnmarshalText(text []byte) error {
+	return f.Parse(string(text))
+}
+
+func (f *FailureActions) Parse(input string) error {
+	f.input = input
+	f.actions = nil
+	for _, v := range strings.Split(input, ";") {
+		args, err := stringutil.SplitFields(strings.TrimSpace(v), " ")
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			continue
+		}
+		a := &failureAction{name: strings.ToLower(args[0])}
+		switch a.name {
+		case failureRestart, failureUnhealthy, failureStop:
+			if len(args) != 1 {
+				return fmt.Errorf("%s action takes no arguments", a.name)
+			}
+		case failureSignal:
+			if len(args) != 2 {
+				return fmt.Errorf("signal action requires a signal")
+			}
+			sig, err := parseSignal(args[1])
+			if err != nil {
+				return err
+			}
+			a.sig = sig
+		case failureRun:
+			if len(args) == 1 {
+				return fmt.Errorf("run action requires a command")
+			}
+			a.argv = args[1:]
+		default:
+			return fmt.Errorf("invalid failure action %q - available actions are restart, signal, run, unhealthy and stop", args[0])
+		}
+		f.actions = append(f.actions, a)
+	}
+	return nil
+}
+
+func (f *FailureActions) String() string {
+	return f.input
+}
+
+// failureResult indicates the actions which affect the watchdog
+// which triggered them.
+type failureResult struct {
+	restarted bool
+	stopped   bool
+}
+
+// execute runs the actions for the given watchdog failure. A nil
+// FailureActions or one without actions restarts the service.
+func (f *FailureActions) execute(s *Service, w *Watchdog, err error) failureResult {
+	actions := []*failureAction{{name: failureRestart}}
+	if f != nil && len(f.actions) > 0 {
+		actions = f.actions
+	}
+	var res failureResult
+	for _, v := range actions {
+		s.infof("running watchdog failure action %s", v)
+		switch v.name {
+		case failureRestart:
+			if err := s.stopService(); err == nil {
+				s.startService()
+			}
+			res.restarted = true
+		case failureSignal:
+			if err := s.Signal(v.sig, false); err != nil {
+				s.errorf("error sending %s: %s", v.sig, err)
+			}
+		case failureRun:
+			if err := runFailureCommand(v.argv, s, w, err); err != nil {
+				s.errorf("error running %s: %s", v.argv, err)
+			}
+		case failureUnhealthy:
+			s.setUnhealthy(err)
+		case failureStop:
+			s.stopService()
+			res.stopped = true
+		}
+	}
+	return res
+}
+
+func runFailureCommand(argv []string, s *Service, w *Watchdog, err error) error {
+	host, _ := os.Hostname()
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(),
+		"GOVERNATOR_HOST="+host,
+		"GOVERNATOR_SERVICE="+s.Name(),
+		"GOVERNATOR_WATCHDOG="+w.String(),
+		"GOVERNATOR_ERROR="+err.Error(),
+	)
+	if pid := s.pid(); pid > 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("GOVERNATOR_PID=%d", pid))
+	}
+	if err := runWithTimeout(cmd, defaultTimeout*time.Second); err != nil {
+		if err == errTimedOut {
+			return fmt.Errorf("timed out after %ds", defaultTimeout)
+		}
+		return err
+	}
+	return nil
+}
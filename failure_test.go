@@ -0,0 +1,175 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFailureActionsParse(t *testing.T) {
+	var f FailureActions
+	if err := f.Parse("signal SIGQUIT; run /bin/echo 'a b'; restart"); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.actions) != 3 || f.actions[0].String() != "signal quit" || len(f.actions[1].argv) != 2 {
+		t.Errorf("unexpected actions %v", f.actions)
+	}
+	for _, v := range []string{"signal", "signal SIGFOO", "run", "restart now", "reboot"} {
+		if err := f.Parse(v); err == nil {
+			t.Errorf("expecting an error parsing %q", v)
+		}
+	}
+}
+
+func TestFailureActions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "governator-failure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	g := prepareGovernatorTest(t)
+	defer afterGovernatorTest(t, g)
+	cfg := &Config{
+		File:      "/non-existant",
+		Command:   "sleep 50000",
+		Name:      "sleep-failure",
+		OnFailure: new(FailureActions),
+	}
+	if err := cfg.OnFailure.Parse("signal CONT; run /bin/sh -c 'echo $GOVERNATOR_SERVICE $GOVERNATOR_ERROR > " + out + "'; unhealthy"); err != nil {
+		t.Fatal(err)
+	}
+	name, err := g.AddService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := g.serviceByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop(name)
+	w := new(Watchdog)
+	if err := w.Parse("run false"); err != nil {
+		t.Fatal(err)
+	}
+	pid := s.pid()
+	res := cfg.OnFailure.execute(s, w, errors.New("check failed"))
+	if res.restarted || res.stopped {
+		t.Errorf("unexpected result %+v", res)
+	}
+	if s.pid() != pid {
+		t.Error("service was restarted")
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != "sleep-failure check failed" {
+		t.Errorf("unexpected command output %q", string(data))
+	}
+	if !s.Unhealthy {
+		t.Error("service was not marked as unhealthy")
+	}
+	s.setUnhealthy(nil)
+	if err := cfg.OnFailure.Parse("stop"); err != nil {
+		t.Fatal(err)
+	}
+	if res := cfg.OnFailure.execute(s, w, errors.New("check failed")); !res.stopped {
+		t.Error("stop action didn't report stopping the service")
+	}
+	if s.pid() != 0 {
+		t.Error("service was not stopped")
+	}
+}
+
+func TestStopDuringFailureAction(t *testing.T) {
+	g := prepareGovernatorTest(t)
+	defer afterGovernatorTest(t, g)
+	w := new(Watchdog)
+	if err := w.Parse("interval=1 run false"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		File:      "/non-existant",
+		Command:   "sleep 50000",
+		Name:      "sleep-failure-stop",
+		Watchdog:  w,
+		OnFailure: new(FailureActions),
+	}
+	// signal locks the service after the command, which
+	// runs while the service is being stopped
+	if err := cfg.OnFailure.Parse("run sleep 1; signal CONT"); err != nil {
+		t.Fatal(err)
+	}
+	setLogger(t, cfg, "none")
+	name, err := g.AddService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+	done := make(chan error, 1)
+	go func() {
+		done <- g.Stop(name)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("deadlock stopping a service while running a failure action")
+	}
+}
+
+func TestStopActionEndsWatchdog(t *testing.T) {
+	g := prepareGovernatorTest(t)
+	defer afterGovernatorTest(t, g)
+	w := new(Watchdog)
+	if err := w.Parse("interval=1 run false"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		File:      "/non-existant",
+		Command:   "sleep 50000",
+		Name:      "sleep-failure-loop",
+		Watchdog:  w,
+		OnFailure: new(FailureActions),
+	}
+	if err := cfg.OnFailure.Parse("stop"); err != nil {
+		t.Fatal(err)
+	}
+	setLogger(t, cfg, "none")
+	name, err := g.AddService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop(name)
+	// The loop signals it has finished once the service is stopped
+	stopped := w.stopped
+	select {
+	case <-stopped:
+		stopped <- true
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchdog loop still running after stopping the service")
+	}
+	// Starting the service again must start a new loop
+	if err := g.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	if w.stopped == stopped {
+		t.Error("watchdog loop was not started again")
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net"
 
 	"path/filepath"
@@ -19,16 +20,31 @@ func formatTime(t time.Time) string {
 
 var (
 	newLine = []byte{'\n'}
+
+	errShuttingDown = errors.New("daemon is shutting down")
 )
 
 type Governator struct {
-	ServerAddr string
-	mu         sync.Mutex
-	services   []*Service
-	configDir  string
-	quit       *quit
-	quits      []*quit
-	monitor    *Monitor
+	ServerAddr  string
+	MetricsAddr string
+	// ReconcileInterval indicates how often the configuration is
+	// reloaded to catch missed changes. Zero disables it.
+	ReconcileInterval time.Duration
+	mu                sync.Mutex
+	services          []*Service
+	configDir         string
+	quit              *quit
+	quits             []*quit
+	// shutdown is closed when the daemon starts exiting
+	shutdown chan struct{}
+	monitor  *Monitor
+	events   *eventBus
+	// dmu protects daemonConfig
+	dmu          sync.Mutex
+	daemonConfig *daemonConfig
+	// reloadMu serializes configuration reloads, which start
+	// and stop services without holding mu
+	reloadMu sync.Mutex
 }
 
 func NewGovernator(configDir string) (*Governator, error) {
@@ -36,20 +52,32 @@ func NewGovernator(configDir string) (*Governator, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Governator{
-		configDir: configDir,
-		monitor:   mon,
-	}, nil
+	g := &Governator{
+		ReconcileInterval: defaultReconcileInterval,
+		configDir:         configDir,
+		monitor:           mon,
+		events:            newEventBus(),
+	}
+	dcfg, err := parseDaemonConfig(configDir)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", g.daemonConfigPath(), err)
+	}
+	g.setDaemonConfig(dcfg)
+	g.ReconcileInterval = dcfg.reconcileInterval()
+	return g, nil
 }
 
-func (g *Governator) ensureUniqueName(cfg *Config) {
+// ensureUniqueName renames the given configuration if its name
+// is already used by any service other than except, which might
+// be nil.
+func (g *Governator) ensureUniqueName(cfg *Config, except *Service) {
 	ii := 1
 	orig := cfg.ServiceName()
 	for {
 		name := cfg.ServiceName()
 		unique := name != "all"
 		for _, v := range g.services {
-			if v != nil && name == v.Name() {
+			if v != nil && v != except && name == v.Name() {
 				unique = false
 				cfg.Name = fmt.Sprintf("%s-%d", orig, ii)
 				ii++
@@ -87,59 +115,57 @@ func (g *Governator) startWatching() error {
 		return err
 	}
 	go func() {
+		// Editors usually perform several writes or write a temporary
+		// file and then rename it, so events are coalesced per file
+		// and only applied after no more events arrive for that file
+		// during configDebounce.
+		pending := make(map[string]time.Time)
+		timer := time.NewTimer(configDebounce)
+		timer.Stop()
 	End:
 		for {
 			select {
 			case ev := <-watcher.Event:
 				log.Debugf("file watcher event %s", ev)
 				name := filepath.Base(ev.Name)
-				if g.shouldIgnoreFile(name, ev.IsDelete() || ev.IsRename()) {
+				// Don't check the file contents yet, they might
+				// not be fully written.
+				if g.shouldIgnoreFile(name, true) {
 					break
 				}
-				g.mu.Lock()
-				switch {
-				case ev.IsCreate():
-					cfg := g.parseConfig(name)
-					// If a file is moved or copied over an already existing
-					// service configuration, we only receive a CREATE. Check
-					// if we already have a configuration with that name and, in
-					// that case, stop it, update its config and restart.
-					if _, s := g.serviceByFilenameLocked(name); s != nil {
-						s.updateConfig(cfg)
-						g.sortServices()
-						s.Stop()
-						s.Start()
-					} else {
-						name, err := g.addServiceLocked(cfg)
-						if err != nil {
-							log.Errorf("error adding service %s: %s", cfg.ServiceName(), err)
-						} else if cfg.Start {
-							log.Debugf("starting service %s", name)
-							s, _ := g.serviceByNameLocked(name)
-							s.Start()
+				pending[name] = time.Now()
+				timer.Reset(configDebounce)
+			case <-timer.C:
+				var next time.Duration
+				for name, t := range pending {
+					if wait := configDebounce - time.Since(t); wait > 0 {
+						if next == 0 || wait < next {
+							next = wait
 						}
+						continue
 					}
-				case ev.IsDelete() || ev.IsRename():
-					if ii, s := g.serviceByFilenameLocked(name); s != nil {
-						log.Debugf("removed service %s", s.Name())
-						if s.State == StateStarted {
-							s.Stop()
-						}
-						g.services = append(g.services[:ii], g.services[ii+1:]...)
+					delete(pending, name)
+					// Decide what to do with g.mu held, but stop
+					// and start the service after releasing it
+					g.reloadMu.Lock()
+					g.mu.Lock()
+					msg, fn := g.applyFileLocked(name)
+					g.mu.Unlock()
+					if msg != "" {
+						log.Infof("configuration changed: %s", msg)
 					}
-				case ev.IsModify():
-					if _, s := g.serviceByFilenameLocked(name); s != nil {
-						cfg := g.parseConfig(name)
-						s.updateConfig(cfg)
-						g.sortServices()
+					if fn != nil {
+						fn()
 					}
-				default:
-					log.Errorf("unhandled event: %s\n", ev)
+					g.reloadMu.Unlock()
+				}
+				if next > 0 {
+					timer.Reset(next)
 				}
-				g.mu.Unlock()
 			case err := <-watcher.Error:
 				log.Errorf("error watching: %s", err)
 			case <-q.stop:
+				timer.Stop()
 				watcher.Close()
 				q.sendStopped()
 				break End
@@ -155,28 +181,96 @@ func (g *Governator) startWatching() error {
 	return nil
 }
 
-func (g *Governator) startService(conn net.Conn, s *Service) error {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-	return g.startServiceLocked(conn, s)
-}
-
-func (g *Governator) startServiceLocked(conn net.Conn, s *Service) error {
+// startService starts the given service, sending the progress to w.
+// It doesn't require g.mu, so services can be started in parallel.
+func (g *Governator) startService(w io.Writer, s *Service) (bool, error) {
 	name := s.Name()
-	encodeResponse(conn, respOk, fmt.Sprintf("starting %s\n", name))
+	encodeResponse(w, respOk, fmt.Sprintf("starting %s\n", name))
 	if serr := s.Start(); serr != nil {
-		return encodeResponse(conn, respErr, fmt.Sprintf("error starting %s: %s\n", name, serr))
+		return false, encodeResponse(w, respErr, fmt.Sprintf("error starting %s: %s\n", name, serr))
 	}
-	return encodeResponse(conn, respOk, fmt.Sprintf("started %s\n", name))
+	return true, encodeResponse(w, respOk, fmt.Sprintf("started %s\n", name))
 }
 
+// bootService holds the settings of a service read by startServices
+// while g.mu is held, since its configuration might be replaced
+// while it's being started.
+type bootService struct {
+	name        string
+	start       bool
+	waitHealthy bool
+	commands    uint32
+}
+
+// startServices starts the services with Start enabled. Services
+// with the same priority form a tier and are started in parallel.
+// The next tier is started once every service in the current one
+// with WaitHealthy enabled is healthy or the tier timeout expires.
+// g.mu is only held while taking a snapshot of the services, so
+// the daemon stays responsive while waiting. Starting is aborted
+// when the daemon shuts down.
 func (g *Governator) startServices(conn net.Conn) error {
 	g.mu.Lock()
-	defer g.mu.Unlock()
-	for _, s := range g.services {
-		if s.Config.Start {
-			g.startServiceLocked(conn, s)
+	tiers := servicesByPriority(g.services).tiers()
+	shutdown := g.shutdown
+	boot := make(map[*Service]bootService, len(g.services))
+	for _, v := range g.services {
+		boot[v] = bootService{
+			name:        v.Name(),
+			start:       v.Config.Start,
+			waitHealthy: v.Config.WaitHealthy,
+			commands:    v.commandCount(),
+		}
+	}
+	g.mu.Unlock()
+	var w io.Writer
+	if conn != nil {
+		w = &lockedWriter{w: conn}
+	}
+	timeout := g.currentDaemonConfig().tierTimeout()
+	for _, tier := range tiers {
+		select {
+		case <-shutdown:
+			return errShuttingDown
+		default:
+		}
+		deadline := time.Now().Add(timeout)
+		var wg sync.WaitGroup
+		for _, s := range tier {
+			if !boot[s].start {
+				continue
+			}
+			wg.Add(1)
+			go func(s *Service) {
+				defer wg.Done()
+				// Skip services removed since the snapshot was taken
+				if !g.hasService(s) {
+					return
+				}
+				name := boot[s].name
+				encodeResponse(w, respOk, fmt.Sprintf("starting %s\n", name))
+				skipped, err := s.startUnlessChanged(boot[s].commands)
+				switch {
+				case err != nil:
+					encodeResponse(w, respErr, fmt.Sprintf("error starting %s: %s\n", name, err))
+					return
+				case skipped:
+					encodeResponse(w, respOk, fmt.Sprintf("%s was started or stopped meanwhile, skipping\n", name))
+					return
+				}
+				encodeResponse(w, respOk, fmt.Sprintf("started %s\n", name))
+				if !boot[s].waitHealthy {
+					return
+				}
+				if err := s.waitHealthy(deadline, shutdown); err != nil {
+					s.errorf("not healthy after %s, starting next services: %s", timeout, err)
+					encodeResponse(w, respErr, fmt.Sprintf("%s is not healthy after %s: %s\n", name, timeout, err))
+					return
+				}
+				encodeResponse(w, respOk, fmt.Sprintf("%s is healthy\n", name))
+			}(s)
 		}
+		wg.Wait()
 	}
 	return nil
 }
@@ -222,6 +316,17 @@ func (g *Governator) serviceByNameLocked(name string) (*Service, error) {
 	return nil, fmt.Errorf("no service named %s", name)
 }
 
+func (g *Governator) hasService(s *Service) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, v := range g.services {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
 func (g *Governator) serviceByName(name string) (*Service, error) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
@@ -229,9 +334,10 @@ func (g *Governator) serviceByName(name string) (*Service, error) {
 }
 
 func (g *Governator) addServiceLocked(cfg *Config) (string, error) {
-	g.ensureUniqueName(cfg)
+	g.ensureUniqueName(cfg, nil)
 	s := newService(cfg)
 	s.monitor = g.monitor
+	s.events = g.events
 	g.services = append(g.services, s)
 	g.sortServices()
 	return cfg.Name, nil
@@ -256,13 +362,19 @@ func (g *Governator) Start(name string) error {
 
 func (g *Governator) Stop(name string) error {
 	if name == "all" {
-		return g.stopServices(nil)
+		err := g.stopServices(nil)
+		g.removeTransients()
+		return err
 	}
 	s, err := g.serviceByName(name)
 	if err != nil {
 		return err
 	}
-	return s.Stop()
+	if err := s.Stop(); err != nil {
+		return err
+	}
+	g.removeTransient(s)
+	return nil
 }
 
 func (g *Governator) State(name string) (State, error) {
@@ -273,6 +385,13 @@ func (g *Governator) State(name string) (State, error) {
 	return s.State, nil
 }
 
+// Events returns a channel which receives the state transitions of
+// the given services, or of all services if none are specified. The
+// returned function must be called to cancel the subscription.
+func (g *Governator) Events(services ...string) (<-chan *Event, func()) {
+	return g.events.Subscribe(services...)
+}
+
 func (g *Governator) LoadServices() error {
 	configs, err := g.parseConfigs()
 	if err != nil {
@@ -291,11 +410,16 @@ func (g *Governator) Run() error {
 		return errors.New("governator already running")
 	}
 	g.quit = newQuit()
+	g.shutdown = make(chan struct{})
 	g.mu.Unlock()
 	go g.monitor.Run()
+	g.startNotifying()
 	if g.configDir != "" {
 		if err := g.startWatching(); err != nil {
-			log.Errorf("error watching %s, configuration won't be automatically updated: %s", g.servicesDir(), err)
+			log.Errorf("error watching %s, configuration won't be automatically updated (use reload-config or SIGHUP): %s", g.servicesDir(), err)
+		}
+		if g.ReconcileInterval > 0 {
+			g.startReconciling(g.ReconcileInterval)
 		}
 	}
 	if g.ServerAddr != "" {
@@ -303,9 +427,19 @@ func (g *Governator) Run() error {
 			log.Errorf("error starting server, can't receive remote commands: %s", err)
 		}
 	}
-	g.startServices(nil)
+	if g.MetricsAddr != "" {
+		if err := g.startMetricsServer(); err != nil {
+			log.Errorf("error starting metrics server on %s: %s", g.MetricsAddr, err)
+		}
+	}
+	started := make(chan struct{})
+	go func() {
+		g.startServices(nil)
+		close(started)
+	}()
 	g.quit.waitForStop()
 	g.mu.Lock()
+	close(g.shutdown)
 	for _, q := range g.quits {
 		q.sendStop()
 	}
@@ -315,6 +449,8 @@ func (g *Governator) Run() error {
 	}
 	g.quits = nil
 	g.mu.Unlock()
+	// Wait for the services being started, so they're stopped too
+	<-started
 	// Release the lock for stopServices
 	g.stopServices(nil)
 	g.mu.Lock()
@@ -324,6 +460,7 @@ func (g *Governator) Run() error {
 	log.Debugf("daemon exiting")
 	g.quit.sendStopped()
 	g.quit = nil
+	g.shutdown = nil
 	return nil
 }
 
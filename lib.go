@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"net/url"
 	"strings"
+	"sync"
 )
 
 const (
@@ -71,18 +73,36 @@ func decodeArgs(r io.Reader) ([]string, error) {
 	return args, nil
 }
 
+// encodeResponse writes the response with a single Write call, so
+// responses sent concurrently to a lockedWriter don't interleave.
 func encodeResponse(w io.Writer, r resp, s string) error {
 	if w != nil {
-		if err := codecWrite(w, r); err != nil {
+		var buf bytes.Buffer
+		if err := codecWrite(&buf, r); err != nil {
 			return err
 		}
-		if err := encodeString(w, s); err != nil {
+		if err := encodeString(&buf, s); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// lockedWriter serializes the writes to w.
+type lockedWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
+
 func decodeResponse(r io.Reader) (resp, string, error) {
 	var re resp
 	if err := codecRead(r, &re); err != nil {
@@ -18,11 +18,26 @@ const (
 )
 
 var (
-	// Altered during tests
+	// Altered during tests and by the daemon configuration,
+	// always use currentLogDir and setLogDir once the daemon
+	// is running.
 	logDir    = LogDir
+	logDirMu  sync.Mutex
 	lineBreak = []byte{'\n'}
 )
 
+func currentLogDir() string {
+	logDirMu.Lock()
+	defer logDirMu.Unlock()
+	return logDir
+}
+
+func setLogDir(dir string) {
+	logDirMu.Lock()
+	logDir = dir
+	logDirMu.Unlock()
+}
+
 type Out struct {
 	Logger *Logger
 	prefix string
@@ -59,6 +74,14 @@ func (o *Out) Write(b []byte) (int, error) {
 	return len(b), nil
 }
 
+// flush writes the buffered partial line, if any
+func (o *Out) flush() {
+	if len(o.buf) > 0 {
+		o.Logger.Write(o.prefix, o.buf)
+		o.buf = o.buf[:0]
+	}
+}
+
 type Writer interface {
 	Open(string) error
 	Close() error
@@ -70,11 +93,13 @@ type LogMonitor func(string, []byte)
 
 type Logger struct {
 	Name    string
+	input   string
 	w       Writer
 	Stdout  *Out
 	Stderr  *Out
 	Monitor LogMonitor
 	buf     []byte
+	written map[string]uint64
 	mu      sync.Mutex
 }
 
@@ -102,6 +127,10 @@ func (l *Logger) Write(prefix string, b []byte) error {
 	if err := l.w.Write(prefix, l.buf); err != nil {
 		return err
 	}
+	if l.written == nil {
+		l.written = make(map[string]uint64)
+	}
+	l.written[prefix] += uint64(len(l.buf))
 	if err := l.w.Flush(); err != nil {
 		return err
 	}
@@ -111,6 +140,18 @@ func (l *Logger) Write(prefix string, b []byte) error {
 	return nil
 }
 
+// BytesWritten returns the number of bytes written
+// by this logger, keyed by prefix (e.g. stdout, stderr).
+func (l *Logger) BytesWritten() map[string]uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	written := make(map[string]uint64, len(l.written))
+	for k, v := range l.written {
+		written[k] = v
+	}
+	return written
+}
+
 func (l *Logger) WriteString(prefix string, s string) {
 	l.Write(prefix, []byte(s))
 }
@@ -119,7 +160,13 @@ func (l *Logger) Flush() {
 	l.w.Flush()
 }
 
+// UnmarshalText parses the logger using Parse.
+func (l *Logger) UnmarshalText(text []byte) error {
+	return l.Parse(string(text))
+}
+
 func (l *Logger) Parse(input string) error {
+	l.input = input
 	if input == "" {
 		input = "file"
 	}
@@ -150,7 +197,7 @@ func (l *Logger) Parse(input string) error {
 		default:
 			return fmt.Errorf("invalid number of arguments for file logger - must be one or two, %d given", len(args)-1)
 		}
-		l.w = &fileWriter{dir: logDir, maxSize: maxSize, count: count}
+		l.w = &fileWriter{dir: currentLogDir(), maxSize: maxSize, count: count}
 	case "syslog":
 		var scheme string
 		var addr string
@@ -27,9 +27,17 @@ func testConfigurations(g *Governator) {
 	for _, v := range configs {
 		fmt.Println("checking", v.Name)
 		if v.Err != nil {
-			fmt.Fprintf(os.Stderr, "error in %s: %s\n", v.Name, v.Err)
+			fmt.Fprintf(os.Stderr, "error in %s: %s\n", v.File, v.Err)
 			ok = false
+			continue
 		}
+		cmd, err := v.Cmd()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error in %s: %s\n", v.Name, err)
+			ok = false
+			continue
+		}
+		fmt.Printf("    argv: %q\n", cmd.Args)
 	}
 	if ok {
 		fmt.Println("configurations OK")
@@ -46,23 +54,52 @@ func main() {
 		daemon       = flag.Bool("D", false, "Run in daemon mode")
 		debug        = flag.Bool("d", false, "Enable debug logging")
 		testConfig   = flag.Bool("t", false, "Test configuration files")
+		diffConfig   = flag.Bool("diff", false, "With -t, show the changes between the configuration directory and the running daemon")
 		configDir    = flag.String("c", defaultConfigDir, "Configuration directory")
 		serverAddr   = flag.String("daemon", "unix://"+socketPath, "Daemon URL to listen on in daemon mode or to connect to in client mode")
+		reconcile    = flag.Duration("reconcile", defaultReconcileInterval, "Interval for reloading the configuration to catch missed changes in daemon mode, 0 disables it")
+		metricsAddr  = flag.String("metrics", "", "Address to serve Prometheus metrics at /metrics in daemon mode (e.g. 127.0.0.1:9120)")
 		printVersion = flag.Bool("V", false, "Print version and exit")
 	)
 	flag.Parse()
 	if *debug {
 		log.SetLevel(log.LDebug)
 	}
+	// Flags given explicitly take precedence over governator.conf
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	dcfg, dcfgErr := parseDaemonConfig(*configDir)
+	if dcfgErr == nil && dcfg.Socket != "" && !explicit["daemon"] {
+		*serverAddr = dcfg.Socket
+	}
 	switch {
 	case *printVersion:
 		fmt.Println(governatorVersion, gitVersion)
 	case *testConfig:
+		fmt.Println("checking", daemonConfigPath(*configDir))
+		if dcfgErr != nil {
+			die(fmt.Errorf("error in %s: %s", daemonConfigPath(*configDir), dcfgErr))
+		}
 		g, err := NewGovernator(*configDir)
 		if err != nil {
 			die(fmt.Errorf("error initializing daemon: %s", err))
 		}
 		testConfigurations(g)
+		if *diffConfig {
+			fmt.Println("changes from running configuration:")
+			diffs, err := g.DiffRunning(*serverAddr)
+			if err != nil {
+				die(fmt.Errorf("error comparing with running configuration: %s", err))
+			}
+			if len(diffs) == 0 {
+				fmt.Println("no changes")
+			}
+			for _, v := range diffs {
+				fmt.Print(v)
+			}
+		}
 	case *daemon:
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt, os.Signal(syscall.SIGTERM), os.Kill)
@@ -74,6 +111,13 @@ func main() {
 			die(fmt.Errorf("error initializing daemon: %s", err))
 		}
 		g.ServerAddr = *serverAddr
+		g.MetricsAddr = g.currentDaemonConfig().Metrics
+		if explicit["metrics"] {
+			g.MetricsAddr = *metricsAddr
+		}
+		if explicit["reconcile"] {
+			g.ReconcileInterval = *reconcile
+		}
 		if err := g.LoadServices(); err != nil {
 			die(fmt.Errorf("error loading services: %s", err))
 		}
@@ -82,9 +126,35 @@ func main() {
 			<-c
 			g.StopRunning()
 		}()
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				log.Infof("received SIGHUP, reloading configuration")
+				if err := g.ReloadDaemonConfig(); err != nil {
+					log.Errorf("error reloading daemon configuration, keeping the current one: %s", err)
+				}
+				actions, err := g.ReloadConfig()
+				if err != nil {
+					log.Errorf("error reloading configuration: %s", err)
+					continue
+				}
+				for _, v := range actions {
+					log.Infof("reloading configuration: %s", v)
+				}
+			}
+		}()
 		if err := g.Run(); err != nil {
 			die(fmt.Errorf("error starting daemon: %s", err))
 		}
+	case flag.Arg(0) == "import":
+		if err := importMain(*configDir, flag.Args()[1:]); err != nil {
+			die(err)
+		}
+	case flag.Arg(0) == "export":
+		if err := exportMain(*configDir, flag.Args()[1:]); err != nil {
+			die(err)
+		}
 	default:
 		ok, err := clientMain(*serverAddr, flag.Args())
 		if err != nil {
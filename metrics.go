@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"gnd.la/log"
+)
+
+var (
+	metricsStates = []State{StateStopped, StateStopping, StateStarted, StateStarting, StateBackoff, StateFailed}
+	labelEscaper  = strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n")
+)
+
+// metricsWriter writes metrics using the Prometheus
+// text exposition format.
+type metricsWriter struct {
+	buf     bytes.Buffer
+	current string
+}
+
+func (w *metricsWriter) header(name string, typ string, help string) {
+	if w.current == name {
+		return
+	}
+	w.current = name
+	fmt.Fprintf(&w.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (w *metricsWriter) value(name string, labels []string, val float64) {
+	w.buf.WriteString(name)
+	if len(labels) > 0 {
+		w.buf.WriteByte('{')
+		for ii := 0; ii < len(labels); ii += 2 {
+			if ii > 0 {
+				w.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&w.buf, "%s=\"%s\"", labels[ii], labelEscaper.Replace(labels[ii+1]))
+		}
+		w.buf.WriteByte('}')
+	}
+	fmt.Fprintf(&w.buf, " %v\n", val)
+}
+
+func (w *metricsWriter) gauge(name string, help string, labels []string, val float64) {
+	w.header(name, "gauge", help)
+	w.value(name, labels, val)
+}
+
+func (w *metricsWriter) counter(name string, help string, labels []string, val float64) {
+	w.header(name, "counter", help)
+	w.value(name, labels, val)
+}
+
+// serviceMetrics is a snapshot of a service, taken with
+// its lock held.
+type serviceMetrics struct {
+	name      string
+	state     State
+	started   time.Time
+	restarts  int
+	retries   int
+	exitCode  int
+	pid       int
+	watchdogs []*Watchdog
+	log       *Logger
+}
+
+func (g *Governator) serviceMetrics() []*serviceMetrics {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	metrics := make([]*serviceMetrics, len(g.services))
+	for ii, v := range g.services {
+		v.mu.Lock()
+		m := &serviceMetrics{
+			name:      v.Name(),
+			state:     v.State,
+			started:   v.Started,
+			restarts:  v.Restarts,
+			retries:   v.retries,
+			exitCode:  v.ExitCode,
+			watchdogs: v.Config.watchdogs(),
+			log:       v.Config.Log,
+		}
+		if v.State.isRunState() && v.Cmd != nil && v.Cmd.Process != nil {
+			m.pid = v.Cmd.Process.Pid
+		}
+		v.mu.Unlock()
+		metrics[ii] = m
+	}
+	return metrics
+}
+
+func (g *Governator) writeMetrics(w *metricsWriter) {
+	services := g.serviceMetrics()
+	w.gauge("governator_services", "Number of registered services.", nil, float64(len(services)))
+	w.gauge("governator_goroutines", "Number of goroutines in the daemon.", nil, float64(runtime.NumGoroutine()))
+	w.gauge("governator_monitor_waiters", "Number of processes waited on by the monitor.", nil, float64(g.monitor.numWaiters()))
+	for _, v := range services {
+		for _, st := range metricsStates {
+			var val float64
+			if v.state == st {
+				val = 1
+			}
+			w.gauge("governator_service_state", "Current state of the service.", []string{"service", v.name, "state", st.String()}, val)
+		}
+	}
+	for _, v := range services {
+		var uptime float64
+		if v.state == StateStarted {
+			uptime = time.Since(v.started).Seconds()
+		}
+		w.gauge("governator_service_uptime_seconds", "Seconds since the service was started.", []string{"service", v.name}, uptime)
+	}
+	for _, v := range services {
+		w.gauge("governator_service_restarts", "Number of restarts since the service was started.", []string{"service", v.name}, float64(v.restarts))
+	}
+	for _, v := range services {
+		w.gauge("governator_service_backoff_retries", "Number of consecutive failed start attempts.", []string{"service", v.name}, float64(v.retries))
+	}
+	for _, v := range services {
+		w.gauge("governator_service_last_exit_code", "Exit code of the last service exit.", []string{"service", v.name}, float64(v.exitCode))
+	}
+	for _, v := range services {
+		for _, wd := range v.watchdogs {
+			_, _, last := wd.Stats()
+			w.gauge("governator_service_watchdog_check_duration_seconds", "Duration of the last watchdog check.", []string{"service", v.name, "watchdog", wd.String()}, last.Seconds())
+		}
+	}
+	for _, v := range services {
+		for _, wd := range v.watchdogs {
+			checks, _, _ := wd.Stats()
+			w.counter("governator_service_watchdog_checks_total", "Number of watchdog checks.", []string{"service", v.name, "watchdog", wd.String()}, float64(checks))
+		}
+	}
+	for _, v := range services {
+		for _, wd := range v.watchdogs {
+			_, failures, _ := wd.Stats()
+			w.counter("governator_service_watchdog_failures_total", "Number of failed watchdog checks.", []string{"service", v.name, "watchdog", wd.String()}, float64(failures))
+		}
+	}
+	stats := make(map[string]*procStat)
+	for _, v := range services {
+		if v.pid > 0 {
+			if st, err := readProcStat(v.pid); err == nil {
+				stats[v.name] = st
+			}
+		}
+	}
+	for _, v := range services {
+		if st := stats[v.name]; st != nil {
+			w.counter("governator_service_cpu_seconds_total", "User and system CPU time spent by the service main process.", []string{"service", v.name}, st.CPUTime().Seconds())
+		}
+	}
+	for _, v := range services {
+		if st := stats[v.name]; st != nil {
+			w.gauge("governator_service_resident_memory_bytes", "Resident memory size of the service main process.", []string{"service", v.name}, float64(st.RSSBytes()))
+		}
+	}
+	for _, v := range services {
+		if v.log == nil {
+			continue
+		}
+		written := v.log.BytesWritten()
+		streams := make([]string, 0, len(written))
+		for k := range written {
+			streams = append(streams, k)
+		}
+		sort.Strings(streams)
+		for _, s := range streams {
+			w.counter("governator_service_log_bytes_total", "Bytes written to the service log, by stream.", []string{"service", v.name, "stream", s}, float64(written[s]))
+		}
+	}
+}
+
+func (g *Governator) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var mw metricsWriter
+	g.writeMetrics(&mw)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(mw.buf.Bytes())
+}
+
+func (g *Governator) startMetricsServer() error {
+	q := newQuit()
+	listener, err := net.Listen("tcp", g.MetricsAddr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", g.serveMetrics)
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			log.Debugf("metrics server exited: %s", err)
+		}
+	}()
+	go func() {
+		<-q.stop
+		listener.Close()
+		q.sendStopped()
+	}()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.quits = append(g.quits, q)
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	g := prepareGovernatorTest(t)
+	defer afterGovernatorTest(t, g)
+	cfg := &Config{
+		File:    "/non-existant",
+		Command: "sleep 50000",
+		Name:    "sleep-metrics",
+	}
+	setLogger(t, cfg, "none")
+	name, err := g.AddService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop(name)
+	var w metricsWriter
+	g.writeMetrics(&w)
+	out := w.buf.String()
+	expect := []string{
+		"# TYPE governator_services gauge\ngovernator_services 1\n",
+		"governator_service_state{service=\"sleep-metrics\",state=\"started\"} 1\n",
+		"governator_service_state{service=\"sleep-metrics\",state=\"stopped\"} 0\n",
+		"governator_service_restarts{service=\"sleep-metrics\"} 0\n",
+		"governator_service_resident_memory_bytes{service=\"sleep-metrics\"}",
+		"governator_service_log_bytes_total{service=\"sleep-metrics\",stream=\"info\"}",
+	}
+	for _, v := range expect {
+		if !strings.Contains(out, v) {
+			t.Errorf("expecting %q in metrics, got\n%s", v, out)
+		}
+	}
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fiam/stringutil"
+	"gopkg.in/yaml.v2"
+)
+
+// importedService is a service translated from another supervisor,
+// written as a YAML service file.
+type importedService struct {
+	Name            string            `yaml:"-"`
+	Command         string            `yaml:"command"`
+	Shell           bool              `yaml:"shell,omitempty"`
+	Dir             string            `yaml:"dir,omitempty"`
+	User            string            `yaml:"user,omitempty"`
+	Group           string            `yaml:"group,omitempty"`
+	Env             map[string]string `yaml:"env,omitempty"`
+	EnvironmentFile string            `yaml:"environment_file,omitempty"`
+	Start           *bool             `yaml:"start,omitempty"`
+	Priority        int               `yaml:"priority,omitempty"`
+	MaxOpenFiles    int               `yaml:"max_open_files,omitempty"`
+	StopTimeout     int               `yaml:"stop_timeout,omitempty"`
+	// Settings which couldn't be translated
+	Warnings []string `yaml:"-"`
+}
+
+func (s *importedService) warnf(format string, args ...interface{}) {
+	s.Warnings = append(s.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (s *importedService) setEnv(key string, value string) {
+	if s.Env == nil {
+		s.Env = make(map[string]string)
+	}
+	s.Env[key] = value
+}
+
+// YAML returns the service file for the imported service. Warnings
+// are included as comments at the top.
+func (s *importedService) YAML(source string) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# imported from %s\n", source)
+	for _, v := range s.Warnings {
+		fmt.Fprintf(&buf, "# warning: %s\n", v)
+	}
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	buf.Write(data)
+	return buf.Bytes(), nil
+}
+
+// iniSection is a section from an INI-like file (systemd units and
+// supervisord configurations), with its keys in order of appearance.
+type iniSection struct {
+	Name   string
+	Keys   []string
+	Values []string
+}
+
+func parseINI(r io.Reader) ([]*iniSection, error) {
+	var sections []*iniSection
+	var cur *iniSection
+	var line string
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasSuffix(text, "\\") {
+			// systemd style line continuation
+			line += strings.TrimSpace(strings.TrimSuffix(text, "\\")) + " "
+			continue
+		}
+		line += text
+		text, line = line, ""
+		if text == "" || text[0] == '#' || text[0] == ';' {
+			continue
+		}
+		if text[0] == '[' {
+			if text[len(text)-1] != ']' {
+				return nil, fmt.Errorf("line %d: invalid section %q", lineno, text)
+			}
+			cur = &iniSection{Name: strings.TrimSpace(text[1 : len(text)-1])}
+			sections = append(sections, cur)
+			continue
+		}
+		if cur == nil {
+			return nil, fmt.Errorf("line %d: key outside of section", lineno)
+		}
+		sep := strings.IndexAny(text, "=:")
+		if sep < 0 {
+			return nil, fmt.Errorf("line %d: invalid line %q", lineno, text)
+		}
+		cur.Keys = append(cur.Keys, strings.TrimSpace(text[:sep]))
+		cur.Values = append(cur.Values, strings.TrimSpace(text[sep+1:]))
+	}
+	return sections, scanner.Err()
+}
+
+// parseSeconds parses a duration in seconds, either as a plain
+// integer or in systemd's time span format (e.g. 1min 30s).
+func parseSeconds(value string) (int, error) {
+	if n, err := strconv.Atoi(value); err == nil {
+		return n, nil
+	}
+	s := strings.Replace(value, " ", "", -1)
+	for _, v := range [][2]string{{"min", "m"}, {"sec", "s"}, {"hr", "h"}} {
+		s = strings.Replace(s, v[0], v[1], -1)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time span %q", value)
+	}
+	return int((d + time.Second - 1) / time.Second), nil
+}
+
+func parseOpenFilesLimit(s *importedService, value string) {
+	// LimitNOFILE=soft:hard
+	if p := strings.IndexByte(value, ':'); p >= 0 {
+		value = value[:p]
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		s.warnf("can't translate open files limit %q", value)
+		return
+	}
+	s.MaxOpenFiles = n
+}
+
+// importSystemd translates a systemd service unit.
+func importSystemd(name string, r io.Reader) ([]*importedService, error) {
+	sections, err := parseINI(r)
+	if err != nil {
+		return nil, err
+	}
+	s := &importedService{Name: strings.TrimSuffix(name, ".service")}
+	privileged := false
+	if strings.HasSuffix(s.Name, "@") {
+		s.Name = strings.TrimSuffix(s.Name, "@")
+		s.warnf("template units aren't supported, %%i specifiers must be replaced by hand")
+	}
+	for _, sec := range sections {
+		for ii, k := range sec.Keys {
+			v := sec.Values[ii]
+			switch sec.Name + "." + k {
+			case "Unit.Description", "Unit.Documentation", "Install.WantedBy", "Install.Alias":
+			case "Unit.After", "Unit.Before", "Unit.Requires", "Unit.Wants", "Unit.BindsTo":
+				s.warnf("%s=%s: dependencies aren't supported, use priority to order services", k, v)
+			case "Service.Type":
+				switch v {
+				case "simple", "exec":
+				default:
+					s.warnf("Type=%s: services must run in the foreground", v)
+				}
+			case "Service.ExecStart":
+				if s.Command != "" {
+					s.warnf("ExecStart=%s: only one command is supported", v)
+					continue
+				}
+				cmd, full, err := translateExecStart(s, v)
+				if err != nil {
+					return nil, err
+				}
+				s.Command = cmd
+				privileged = privileged || full
+			case "Service.WorkingDirectory":
+				s.Dir = strings.TrimPrefix(v, "-")
+			case "Service.User":
+				s.User = v
+			case "Service.Group":
+				s.Group = v
+			case "Service.Environment":
+				fields, err := stringutil.SplitFields(v, " ")
+				if err != nil {
+					return nil, err
+				}
+				for _, f := range fields {
+					if p := strings.IndexByte(f, '='); p > 0 {
+						s.setEnv(f[:p], f[p+1:])
+					}
+				}
+			case "Service.EnvironmentFile":
+				if s.EnvironmentFile != "" {
+					s.EnvironmentFile += " "
+				}
+				s.EnvironmentFile += v
+			case "Service.Restart":
+				if v != "always" {
+					s.warnf("Restart=%s: services are always restarted when they exit", v)
+				}
+			case "Service.LimitNOFILE":
+				parseOpenFilesLimit(s, v)
+			case "Service.TimeoutStopSec":
+				n, err := parseSeconds(v)
+				if err != nil {
+					s.warnf("TimeoutStopSec=%s: %s", v, err)
+					continue
+				}
+				s.StopTimeout = n
+			default:
+				s.warnf("[%s] %s=%s is not supported", sec.Name, k, v)
+			}
+		}
+	}
+	if s.Command == "" {
+		return nil, fmt.Errorf("%s has no ExecStart", name)
+	}
+	if privileged && (s.User != "" || s.Group != "") {
+		// The command runs as root, ignoring User= and Group=
+		s.warnf("ExecStart runs with full privileges, ignoring User=%s and Group=%s", s.User, s.Group)
+		s.User = ""
+		s.Group = ""
+	}
+	if strings.Contains(s.Command, "%") {
+		s.warnf("command contains specifiers, which aren't supported")
+	}
+	return []*importedService{s}, nil
+}
+
+// translateExecStart translates a systemd ExecStart command line,
+// handling its special executable prefixes. The second return value
+// indicates if the command must run with full privileges.
+func translateExecStart(s *importedService, value string) (string, bool, error) {
+	cmd := value
+	argv0 := false
+	privileged := false
+Prefixes:
+	for len(cmd) > 0 {
+		switch cmd[0] {
+		case '@':
+			// the second word is passed as argv[0]
+			argv0 = true
+		case '-':
+			// failures are ignored, governator restarts the
+			// service regardless of its exit status
+		case ':':
+			s.warnf("ExecStart=%s: environment variables in the command are always expanded", value)
+		case '+', '!':
+			if strings.HasPrefix(cmd, "!!") {
+				s.warnf("ExecStart=%s: !! depends on ambient capabilities, translated as !", value)
+				cmd = cmd[1:]
+			}
+			privileged = true
+		default:
+			break Prefixes
+		}
+		cmd = cmd[1:]
+	}
+	if argv0 {
+		args, err := stringutil.SplitFields(cmd, " ")
+		if err != nil {
+			return "", false, err
+		}
+		if len(args) < 2 {
+			return "", false, fmt.Errorf("ExecStart=%s: @ requires the executable and argv[0]", value)
+		}
+		s.warnf("ExecStart=%s: argv[0] can't be set, %s is run with its own name", value, args[0])
+		args = append(args[:1], args[2:]...)
+		for ii, v := range args {
+			args[ii] = commandQuote(v)
+		}
+		cmd = strings.Join(args, " ")
+	}
+	return cmd, privileged, nil
+}
+
+// commandQuote quotes an argument for a service command, if required.
+func commandQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\") {
+		return arg
+	}
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+	return "\"" + r.Replace(arg) + "\""
+}
+
+// parseSupervisordBool parses a boolean value using the same
+// rules as supervisord.
+func parseSupervisordBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean %q", value)
+}
+
+// importSupervisord translates the [program:x] sections from
+// a supervisord configuration file.
+func importSupervisord(name string, r io.Reader) ([]*importedService, error) {
+	sections, err := parseINI(r)
+	if err != nil {
+		return nil, err
+	}
+	var services []*importedService
+	for _, sec := range sections {
+		if !strings.HasPrefix(sec.Name, "program:") {
+			continue
+		}
+		s := &importedService{Name: strings.TrimPrefix(sec.Name, "program:")}
+		for ii, k := range sec.Keys {
+			v := sec.Values[ii]
+			switch k {
+			case "command":
+				s.Command = v
+			case "directory":
+				s.Dir = v
+			case "user":
+				s.User = v
+			case "environment":
+				fields, err := stringutil.SplitFields(v, ",")
+				if err != nil {
+					return nil, err
+				}
+				for _, f := range fields {
+					if p := strings.IndexByte(f, '='); p > 0 {
+						s.setEnv(strings.TrimSpace(f[:p]), f[p+1:])
+					}
+				}
+			case "autostart":
+				start, err := parseSupervisordBool(v)
+				if err != nil {
+					s.warnf("autostart=%s: %s", v, err)
+					continue
+				}
+				s.Start = &start
+			case "autorestart":
+				if restart, err := parseSupervisordBool(v); err != nil || !restart {
+					s.warnf("autorestart=%s: services are always restarted when they exit", v)
+				}
+			case "priority":
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					s.warnf("invalid priority %q", v)
+					continue
+				}
+				s.Priority = n
+			case "stopwaitsecs":
+				n, err := strconv.Atoi(v)
+				if err != nil {
+					s.warnf("invalid stopwaitsecs %q", v)
+					continue
+				}
+				s.StopTimeout = n
+			case "stopsignal":
+				if sig := strings.ToUpper(v); sig != "TERM" && sig != "SIGTERM" {
+					s.warnf("stopsignal=%s: services are always stopped with SIGTERM", v)
+				}
+			case "minfds":
+				parseOpenFilesLimit(s, v)
+			case "stdout_logfile", "stderr_logfile", "redirect_stderr":
+				s.warnf("%s=%s: output is handled by the service logger", k, v)
+			default:
+				s.warnf("%s=%s is not supported", k, v)
+			}
+		}
+		if s.Command == "" {
+			return nil, fmt.Errorf("%s: [%s] has no command", name, sec.Name)
+		}
+		if strings.Contains(s.Command, "%(") {
+			s.warnf("command contains %%(...)s expansions, which aren't supported")
+		}
+		services = append(services, s)
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("%s has no [program:x] sections", name)
+	}
+	return services, nil
+}
+
+// importProcfile translates the processes in a Procfile. Their
+// commands are run by the shell, like most Procfile runners do.
+func importProcfile(name string, r io.Reader) ([]*importedService, error) {
+	var services []*importedService
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		p := strings.IndexByte(line, ':')
+		if p <= 0 {
+			return nil, fmt.Errorf("%s line %d: invalid process %q", name, lineno, line)
+		}
+		s := &importedService{
+			Name:    strings.TrimSpace(line[:p]),
+			Command: strings.TrimSpace(line[p+1:]),
+			Shell:   true,
+		}
+		if strings.Contains(s.Command, "$PORT") {
+			s.warnf("PORT is not set, add it to env")
+		}
+		services = append(services, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("%s has no processes", name)
+	}
+	return services, nil
+}
+
+// importFile translates the given file, choosing the importer
+// from its name and contents.
+func importFile(path string) ([]*importedService, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(path)
+	switch {
+	case strings.HasSuffix(name, ".service"):
+		return importSystemd(name, bytes.NewReader(data))
+	case strings.HasPrefix(name, "Procfile"):
+		return importProcfile(name, bytes.NewReader(data))
+	case bytes.Contains(data, []byte("[program:")):
+		return importSupervisord(name, bytes.NewReader(data))
+	}
+	return nil, fmt.Errorf("can't determine the format of %s - supported formats are systemd .service units, supervisord configurations and Procfiles", path)
+}
+
+// importMain implements governator import [-o dir] [-f] <file...>
+func importMain(configDir string, args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	outDir := flags.String("o", filepath.Join(configDir, "services"), "Directory to write the service files to")
+	force := flags.Bool("f", false, "Overwrite existing service files")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("usage: import [-o dir] [-f] <file...>")
+	}
+	for _, p := range flags.Args() {
+		services, err := importFile(p)
+		if err != nil {
+			return err
+		}
+		for _, s := range services {
+			data, err := s.YAML(p)
+			if err != nil {
+				return err
+			}
+			out := filepath.Join(*outDir, s.Name+".yaml")
+			if _, err := os.Stat(out); err == nil && !*force {
+				return fmt.Errorf("%s already exists, use -f to overwrite it", out)
+			}
+			if err := ioutil.WriteFile(out, data, 0644); err != nil {
+				return err
+			}
+			fmt.Printf("imported %s from %s into %s\n", s.Name, p, out)
+			for _, w := range s.Warnings {
+				fmt.Fprintf(os.Stderr, "warning: %s: %s\n", s.Name, w)
+			}
+		}
+	}
+	return nil
+}
+
+// systemdQuote quotes an argument for ExecStart, if required.
+func systemdQuote(arg string) string {
+	arg = strings.Replace(arg, "%", "%%", -1)
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\;") {
+		return arg
+	}
+	r := strings.NewReplacer("\\", "\\\\", "\"", "\\\"")
+	return "\"" + r.Replace(arg) + "\""
+}
+
+// exportSystemd writes a systemd unit for the given service to w and
+// returns the settings which couldn't be exported.
+func exportSystemd(w io.Writer, cfg *Config) ([]string, error) {
+	var warnings []string
+	// Keep the variables, so systemd expands them when
+	// starting the service
+	argv, err := cfg.argv(func(key string) (string, bool) { return "${" + key + "}", true })
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(argv[0]) {
+		if p, err := exec.LookPath(argv[0]); err == nil {
+			argv[0] = p
+		}
+	}
+	quoted := make([]string, len(argv))
+	for ii, v := range argv {
+		quoted[ii] = systemdQuote(v)
+	}
+	fmt.Fprintf(w, "[Unit]\nDescription=%s (exported from %s)\n\n", cfg.ServiceName(), AppName)
+	fmt.Fprintf(w, "[Service]\nExecStart=%s\nRestart=always\n", strings.Join(quoted, " "))
+	if cfg.Dir != "" {
+		fmt.Fprintf(w, "WorkingDirectory=%s\n", cfg.Dir)
+	}
+	if cfg.User != "" {
+		fmt.Fprintf(w, "User=%s\n", cfg.User)
+	}
+	if cfg.Group != "" {
+		fmt.Fprintf(w, "Group=%s\n", cfg.Group)
+	}
+	keys := make([]string, 0, len(cfg.Env))
+	for k := range cfg.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "Environment=%s\n", systemdQuote(k+"="+cfg.Env[k]))
+	}
+	if cfg.EnvironmentFile != "" {
+		files, optional, err := cfg.environmentFiles()
+		if err != nil {
+			return nil, err
+		}
+		for ii, v := range files {
+			if optional[ii] {
+				v = "-" + v
+			}
+			fmt.Fprintf(w, "EnvironmentFile=%s\n", v)
+		}
+	}
+	if cfg.MaxOpenFiles > 0 {
+		fmt.Fprintf(w, "LimitNOFILE=%d\n", cfg.MaxOpenFiles)
+	}
+	fmt.Fprintf(w, "TimeoutStopSec=%d\n", int(cfg.stopTimeout()/time.Second))
+	if cfg.Shell {
+		warnings = append(warnings, fmt.Sprintf("shell command runs with %s -c, but systemd expands its $ variables before the shell does", shellPath))
+	}
+	for _, v := range cfg.watchdogs() {
+		warnings = append(warnings, fmt.Sprintf("watchdog %q can't be exported", v.input))
+	}
+	if cfg.OnFailure != nil && cfg.OnFailure.input != "" {
+		warnings = append(warnings, fmt.Sprintf("on_failure %q can't be exported, systemd only restarts services when they exit", cfg.OnFailure.input))
+	}
+	if cfg.WaitHealthy {
+		warnings = append(warnings, "wait_healthy can't be exported, dependent units start as soon as the service does")
+	}
+	if _, ok := cfg.Env["GOMAXPROCS"]; !ok && cfg.GoMaxProcs != "" {
+		warnings = append(warnings, fmt.Sprintf("go_max_procs %q can't be exported, set GOMAXPROCS in the environment", cfg.GoMaxProcs))
+	}
+	if cfg.Log != nil && cfg.Log.input != "" {
+		warnings = append(warnings, fmt.Sprintf("log %q can't be exported, output goes to the journal", cfg.Log.input))
+	}
+	if cfg.Notify != nil {
+		warnings = append(warnings, fmt.Sprintf("notify %q can't be exported", cfg.Notify.input))
+	}
+	if cfg.CleanEnvironment || cfg.PassEnvironment != "" {
+		warnings = append(warnings, "systemd services never inherit the environment, PassEnvironment must be set by hand")
+	}
+	if cfg.Start {
+		fmt.Fprint(w, "\n[Install]\nWantedBy=multi-user.target\n")
+	}
+	return warnings, nil
+}
+
+// exportMain implements governator export systemd <service>
+func exportMain(configDir string, args []string) error {
+	if len(args) != 2 || args[0] != "systemd" {
+		return errors.New("usage: export systemd <service>")
+	}
+	g, err := NewGovernator(configDir)
+	if err != nil {
+		return err
+	}
+	configs, err := g.parseConfigs()
+	if err != nil {
+		return err
+	}
+	for _, v := range configs {
+		if v.Name != args[1] {
+			continue
+		}
+		if v.Err != nil {
+			return fmt.Errorf("error in %s: %s", v.File, v.Err)
+		}
+		warnings, err := exportSystemd(os.Stdout, v)
+		if err != nil {
+			return err
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+		}
+		return nil
+	}
+	return fmt.Errorf("no service named %s", args[1])
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const systemdUnit = `[Unit]
+Description=Web server
+After=network.target
+
+[Service]
+Type=simple
+ExecStart=/usr/bin/web --port 8080 \
+	--verbose
+WorkingDirectory=/srv/web
+User=www
+Environment="GREETING=hello world" MODE=prod
+EnvironmentFile=-/etc/default/web
+Restart=on-failure
+LimitNOFILE=4096:8192
+TimeoutStopSec=1min 30s
+ProtectSystem=full
+
+[Install]
+WantedBy=multi-user.target
+`
+
+const supervisordConf = `[supervisord]
+logfile=/var/log/supervisord.log
+
+[program:worker]
+command=/usr/bin/worker -q default
+directory=/srv/worker
+environment=A="1,2",B=3
+autostart=false
+priority=10
+stopwaitsecs=30
+stdout_logfile=/var/log/worker.log
+`
+
+const procfile = `# processes
+web: bundle exec rails server -p $PORT
+worker: bundle exec sidekiq
+`
+
+func TestImportSystemd(t *testing.T) {
+	services, err := importSystemd("web.service", strings.NewReader(systemdUnit))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := services[0]
+	expect := &importedService{
+		Name:            "web",
+		Command:         "/usr/bin/web --port 8080 --verbose",
+		Dir:             "/srv/web",
+		User:            "www",
+		Env:             map[string]string{"GREETING": "hello world", "MODE": "prod"},
+		EnvironmentFile: "-/etc/default/web",
+		MaxOpenFiles:    4096,
+		StopTimeout:     90,
+	}
+	warnings := s.Warnings
+	s.Warnings = nil
+	if !reflect.DeepEqual(s, expect) {
+		t.Errorf("expecting %+v, got %+v", expect, s)
+	}
+	// After, Restart and ProtectSystem
+	if len(warnings) != 3 {
+		t.Errorf("expecting 3 warnings, got %q", warnings)
+	}
+}
+
+func TestImportSystemdPrefixes(t *testing.T) {
+	tests := []struct {
+		exec     string
+		user     string
+		command  string
+		warnings int
+	}{
+		{"-/usr/bin/web --port 8080", "www", "/usr/bin/web --port 8080", 0},
+		{"@/usr/bin/web web-server --name \"a b\"", "www", "/usr/bin/web --name \"a b\"", 1},
+		{"-@/usr/bin/web web-server", "www", "/usr/bin/web", 1},
+		{":/usr/bin/web $PORT", "www", "/usr/bin/web $PORT", 1},
+		{"+/usr/bin/web", "", "/usr/bin/web", 1},
+		{"!/usr/bin/web", "", "/usr/bin/web", 1},
+		{"!!/usr/bin/web", "", "/usr/bin/web", 2},
+	}
+	for _, v := range tests {
+		unit := "[Service]\nExecStart=" + v.exec + "\nUser=www\n"
+		services, err := importSystemd("web.service", strings.NewReader(unit))
+		if err != nil {
+			t.Errorf("error importing ExecStart=%s: %s", v.exec, err)
+			continue
+		}
+		s := services[0]
+		if s.Command != v.command || s.User != v.user {
+			t.Errorf("ExecStart=%s: expecting command %q and user %q, got %q and %q", v.exec, v.command, v.user, s.Command, s.User)
+		}
+		if len(s.Warnings) != v.warnings {
+			t.Errorf("ExecStart=%s: expecting %d warnings, got %q", v.exec, v.warnings, s.Warnings)
+		}
+	}
+	if _, err := importSystemd("web.service", strings.NewReader("[Service]\nExecStart=@/usr/bin/web\n")); err == nil {
+		t.Error("expecting an error with @ and no argv[0]")
+	}
+}
+
+func TestImportSupervisord(t *testing.T) {
+	services, err := importSupervisord("supervisord.conf", strings.NewReader(supervisordConf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 1 {
+		t.Fatalf("expecting 1 service, got %d", len(services))
+	}
+	s := services[0]
+	if s.Name != "worker" || s.Command != "/usr/bin/worker -q default" || s.Dir != "/srv/worker" ||
+		s.Priority != 10 || s.StopTimeout != 30 || s.Start == nil || *s.Start {
+		t.Errorf("unexpected service %+v", s)
+	}
+	if !reflect.DeepEqual(s.Env, map[string]string{"A": "1,2", "B": "3"}) {
+		t.Errorf("unexpected environment %v", s.Env)
+	}
+	if len(s.Warnings) != 1 {
+		t.Errorf("expecting 1 warning, got %q", s.Warnings)
+	}
+	for _, v := range []string{"false", "False", "FALSE", "no", "off", "0"} {
+		services, err := importSupervisord("supervisord.conf", strings.NewReader("[program:x]\ncommand=x\nautostart="+v+"\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := services[0]; s.Start == nil || *s.Start || len(s.Warnings) != 0 {
+			t.Errorf("autostart=%s: expecting start = false without warnings, got %v, %q", v, s.Start, s.Warnings)
+		}
+	}
+	for _, v := range []string{"True", "yes", "ON", "1"} {
+		services, err := importSupervisord("supervisord.conf", strings.NewReader("[program:x]\ncommand=x\nautostart="+v+"\nautorestart="+v+"\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := services[0]; s.Start == nil || !*s.Start || len(s.Warnings) != 0 {
+			t.Errorf("autostart=%s: expecting start = true without warnings, got %v, %q", v, s.Start, s.Warnings)
+		}
+	}
+}
+
+func TestImportProcfile(t *testing.T) {
+	services, err := importProcfile("Procfile", strings.NewReader(procfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(services) != 2 {
+		t.Fatalf("expecting 2 services, got %d", len(services))
+	}
+	if s := services[1]; s.Name != "worker" || s.Command != "bundle exec sidekiq" || !s.Shell {
+		t.Errorf("unexpected service %+v", s)
+	}
+	if len(services[0].Warnings) != 1 {
+		t.Errorf("expecting a warning about PORT, got %q", services[0].Warnings)
+	}
+	// Imported services must be valid service files
+	data, err := services[0].YAML("Procfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg Config
+	if err := decodeYAMLConfig(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Command != services[0].Command || !cfg.Shell {
+		t.Errorf("unexpected config from %s", string(data))
+	}
+}
+
+func TestExportSystemd(t *testing.T) {
+	args := Argv{"/usr/bin/web", "--name", "a b"}
+	cfg := &Config{
+		Name:         "web",
+		Args:         &args,
+		User:         "www",
+		Env:          map[string]string{"MODE": "prod"},
+		MaxOpenFiles: 1024,
+		Start:        true,
+	}
+	var buf bytes.Buffer
+	if _, err := exportSystemd(&buf, cfg); err != nil {
+		t.Fatal(err)
+	}
+	unit := buf.String()
+	for _, v := range []string{
+		"ExecStart=/usr/bin/web --name \"a b\"\n",
+		"User=www\n",
+		"Environment=MODE=prod\n",
+		"LimitNOFILE=1024\n",
+		"TimeoutStopSec=10\n",
+		"WantedBy=multi-user.target\n",
+	} {
+		if !strings.Contains(unit, v) {
+			t.Errorf("expecting %q in unit:\n%s", v, unit)
+		}
+	}
+}
+
+func TestExportSystemdWarnings(t *testing.T) {
+	parse := func(w *Watchdog, s string) *Watchdog {
+		if err := w.Parse(s); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+	onFailure := new(FailureActions)
+	if err := onFailure.Parse("signal HUP; restart"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		Name:        "web",
+		Command:     "web --port $PORT",
+		Shell:       true,
+		Watchdog:    parse(new(Watchdog), "run true"),
+		Watchdogs:   []*Watchdog{parse(new(Watchdog), "run false")},
+		OnFailure:   onFailure,
+		WaitHealthy: true,
+		GoMaxProcs:  "cgroup",
+	}
+	var buf bytes.Buffer
+	warnings, err := exportSystemd(&buf, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"shell", "\"run true\"", "\"run false\"", "on_failure", "wait_healthy", "go_max_procs"} {
+		found := false
+		for _, w := range warnings {
+			if strings.Contains(w, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("expecting a warning about %s, got %q", v, warnings)
+		}
+	}
+	if len(warnings) != 6 {
+		t.Errorf("expecting 6 warnings, got %q", warnings)
+	}
+	// GOMAXPROCS set explicitly is exported with the environment
+	cfg = &Config{Name: "web", Command: "web", GoMaxProcs: "2", Env: map[string]string{"GOMAXPROCS": "2"}}
+	buf.Reset()
+	if warnings, err := exportSystemd(&buf, cfg); err != nil || len(warnings) != 0 {
+		t.Errorf("expecting no warnings, got %q (error %v)", warnings, err)
+	}
+}
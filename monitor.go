@@ -12,6 +12,14 @@ import (
 	"github.com/rainycape/aio"
 )
 
+// exitStatusError is passed to the waiter function
+// when a command exits with a non-zero status
+type exitStatusError int
+
+func (e exitStatusError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
 type waiter struct {
 	cmd     *exec.Cmd
 	fn      func(error)
@@ -77,7 +85,7 @@ func (m *Monitor) waitForExited() {
 			exitStatus := ws.ExitStatus()
 			var err error
 			if exitStatus != 0 {
-				err = fmt.Errorf("exit status %d", exitStatus)
+				err = exitStatusError(exitStatus)
 			}
 			v.fn(err)
 			m.removeCmd(v.cmd)
@@ -85,6 +93,14 @@ func (m *Monitor) waitForExited() {
 	}
 }
 
+// numWaiters returns the number of commands
+// currently being monitored
+func (m *Monitor) numWaiters() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.waiters)
+}
+
 func (m *Monitor) Run() {
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, os.Signal(syscall.SIGCHLD))
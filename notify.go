@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gnd.la/log"
+
+	"github.com/fiam/stringutil"
+)
+
+const (
+	defaultNotifyRetries = 3
+	defaultNotifyTimeout = 10
+	// maximum number of events waiting to be delivered by
+	// each notifier, newer events are dropped when it's full
+	notifyQueueSize = 100
+)
+
+var (
+	// Altered during tests
+	notifyRetryDelay = time.Second
+	// Events which trigger a notification when no
+	// events are explicitly configured.
+	defaultNotifyOn = []EventType{EventFailed, EventWatchdogFailed}
+)
+
+// notification is the payload sent by webhook notifiers
+type notification struct {
+	Host string `json:"host"`
+	*Event
+}
+
+// Notifier delivers events either by POSTing them to
+// a webhook or by running a command. Its configuration
+// syntax is:
+//
+//	webhook <url> [on=event1,event2...] [retries=N] [timeout=N]
+//	command [on=event1,event2...] [timeout=N] <cmd> [args...]
+type Notifier struct {
+	input   string
+	url     string
+	argv    []string
+	on      []EventType
+	retries int
+	timeout int
+	// mu protects the events waiting to be delivered
+	mu         sync.Mutex
+	queue      []*Event
+	delivering bool
+}
+
+// UnmarshalText parses the notifier using Parse.
+func (n *Notifier) UnmarshalText(text []byte) error {
+	return n.Parse(string(text))
+}
+
+func (n *Notifier) Parse(input string) error {
+	n.input = input
+	if input == "" {
+		return nil
+	}
+	args, err := stringutil.SplitFields(input, " ")
+	if err != nil {
+		return err
+	}
+	var opts map[string]string
+	switch strings.ToLower(args[0]) {
+	case "webhook":
+		if len(args) < 2 {
+			return fmt.Errorf("webhook notifier requires an URL")
+		}
+		u, err := url.Parse(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid webhook URL %q: %s", args[1], err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("invalid webhook URL scheme %q - must be http or https", u.Scheme)
+		}
+		var rem []string
+		opts, rem = parseOptions(args[2:])
+		if len(rem) > 0 {
+			return fmt.Errorf("invalid webhook notifier option %q", rem[0])
+		}
+		n.url = args[1]
+	case "command":
+		var rem []string
+		opts, rem = parseOptions(args[1:])
+		if len(rem) == 0 {
+			return fmt.Errorf("command notifier requires a command")
+		}
+		n.argv = rem
+	default:
+		return fmt.Errorf("invalid notifier %q - available notifiers are webhook and command", args[0])
+	}
+	n.retries = defaultNotifyRetries
+	n.timeout = defaultNotifyTimeout
+	for k, v := range opts {
+		switch k {
+		case "on":
+			n.on = nil
+			for _, e := range strings.Split(v, ",") {
+				var t EventType
+				if err := t.UnmarshalText([]byte(e)); err != nil {
+					return err
+				}
+				n.on = append(n.on, t)
+			}
+		case "retries":
+			r, err := strconv.Atoi(v)
+			if err != nil || r < 0 {
+				return fmt.Errorf("invalid notifier retries %q, must be a non-negative integer", v)
+			}
+			n.retries = r
+		case "timeout":
+			t, err := strconv.Atoi(v)
+			if err != nil || t <= 0 {
+				return fmt.Errorf("invalid notifier timeout %q, must be a positive integer", v)
+			}
+			n.timeout = t
+		default:
+			return fmt.Errorf("unknown notifier option %q", k)
+		}
+	}
+	return nil
+}
+
+func (n *Notifier) String() string {
+	if n.url != "" {
+		return fmt.Sprintf("webhook: %s", n.url)
+	}
+	return fmt.Sprintf("command: %s", n.argv)
+}
+
+// Wants returns true iff the notifier should fire for the given event.
+func (n *Notifier) Wants(ev *Event) bool {
+	on := n.on
+	if len(on) == 0 {
+		on = defaultNotifyOn
+	}
+	for _, v := range on {
+		if v == ev.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Notify delivers the event, retrying with exponential
+// backoff if the delivery fails.
+func (n *Notifier) Notify(ev *Event) error {
+	var err error
+	delay := notifyRetryDelay
+	for ii := 0; ii <= n.retries; ii++ {
+		if ii > 0 {
+			log.Debugf("retrying notification %s in %s: %s", n, delay, err)
+			time.Sleep(delay)
+			delay *= 2
+		}
+		if n.url != "" {
+			err = n.post(ev)
+		} else {
+			err = n.run(ev)
+		}
+		if err == nil {
+			break
+		}
+	}
+	return err
+}
+
+// enqueue adds the event to the ones waiting to be delivered.
+// Events are delivered in order by a single goroutine, which
+// exits once the queue is empty.
+func (n *Notifier) enqueue(ev *Event) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if len(n.queue) >= notifyQueueSize {
+		log.Errorf("too many pending notifications for %s, dropping %s", n, ev)
+		return
+	}
+	n.queue = append(n.queue, ev)
+	if !n.delivering {
+		n.delivering = true
+		go n.deliver()
+	}
+}
+
+func (n *Notifier) deliver() {
+	for {
+		n.mu.Lock()
+		if len(n.queue) == 0 {
+			n.delivering = false
+			n.mu.Unlock()
+			return
+		}
+		ev := n.queue[0]
+		n.queue = n.queue[1:]
+		n.mu.Unlock()
+		if err := n.Notify(ev); err != nil {
+			log.Errorf("error notifying %s about %s: %s", n, ev, err)
+		}
+	}
+}
+
+func (n *Notifier) post(ev *Event) error {
+	host, _ := os.Hostname()
+	data, err := json.Marshal(&notification{Host: host, Event: ev})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", fmt.Sprintf("%s notifier", AppName))
+	client := &http.Client{Timeout: time.Duration(n.timeout) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned non-2xx code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (n *Notifier) run(ev *Event) error {
+	host, _ := os.Hostname()
+	cmd := exec.Command(n.argv[0], n.argv[1:]...)
+	cmd.Env = append(os.Environ(),
+		"GOVERNATOR_HOST="+host,
+		"GOVERNATOR_SERVICE="+ev.Service,
+		"GOVERNATOR_EVENT="+ev.Type.String(),
+		"GOVERNATOR_STATE="+ev.State.String(),
+		"GOVERNATOR_ERROR="+ev.Err,
+		"GOVERNATOR_TIME="+ev.Time.Format(time.RFC3339),
+	)
+	if err := runWithTimeout(cmd, time.Duration(n.timeout)*time.Second); err != nil {
+		if err == errTimedOut {
+			return fmt.Errorf("notify command timed out after %ds", n.timeout)
+		}
+		return err
+	}
+	return nil
+}
+
+// startNotifying delivers the events from all services to
+// their notifiers as well as to the global one.
+func (g *Governator) startNotifying() {
+	q := newQuit()
+	ch, cancel := g.Events()
+	// Events are passed in order to a single goroutine, since
+	// notify() needs to acquire g.mu, which is held while stopping
+	// this one. It doesn't wait for the deliveries, so a slow
+	// notifier doesn't delay the others.
+	pending := make(chan *Event, notifyQueueSize)
+	go func() {
+		for ev := range pending {
+			g.notify(ev)
+		}
+	}()
+	go func() {
+		for {
+			select {
+			case ev := <-ch:
+				select {
+				case pending <- ev:
+				default:
+					log.Errorf("too many pending notifications, dropping %s", ev)
+				}
+			case <-q.stop:
+				cancel()
+				close(pending)
+				q.sendStopped()
+				return
+			}
+		}
+	}()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.quits = append(g.quits, q)
+}
+
+func (g *Governator) notify(ev *Event) {
+	var notifiers []*Notifier
+	g.mu.Lock()
+	if s, err := g.serviceByNameLocked(ev.Service); err == nil && s.Config.Notify != nil {
+		notifiers = append(notifiers, s.Config.Notify)
+	}
+	g.mu.Unlock()
+	if dcfg := g.currentDaemonConfig(); dcfg.Notify != nil {
+		notifiers = append(notifiers, dcfg.Notify)
+	}
+	for _, v := range notifiers {
+		if v.Wants(ev) {
+			v.enqueue(ev)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNotifierParse(t *testing.T) {
+	tests := []struct {
+		config string
+		err    string
+	}{
+		{"webhook http://127.0.0.1/hook", ""},
+		{"webhook http://127.0.0.1/hook on=failed,backoff retries=1 timeout=5", ""},
+		{"webhook ftp://127.0.0.1/hook", "invalid webhook URL scheme \"ftp\" - must be http or https"},
+		{"webhook http://127.0.0.1/hook on=exploded", "invalid event type \"exploded\""},
+		{"webhook http://127.0.0.1/hook foo", "invalid webhook notifier option \"foo\""},
+		{"command on=failed /usr/bin/logger -t governator", ""},
+		{"command on=failed", "command notifier requires a command"},
+		{"carrier-pigeon", "invalid notifier \"carrier-pigeon\" - available notifiers are webhook and command"},
+	}
+	for _, v := range tests {
+		n := new(Notifier)
+		checkExpectedErr(t, n.Parse(v.config), v.err)
+	}
+}
+
+func TestNotifierWebhook(t *testing.T) {
+	old := notifyRetryDelay
+	notifyRetryDelay = 10 * time.Millisecond
+	defer func() { notifyRetryDelay = old }()
+	var mu sync.Mutex
+	var received []*notification
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			// Fail the first delivery, to test retries
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		n := &notification{Event: &Event{}}
+		if err := json.NewDecoder(r.Body).Decode(n); err != nil {
+			t.Error(err)
+		}
+		received = append(received, n)
+	}))
+	defer srv.Close()
+	n := new(Notifier)
+	if err := n.Parse("webhook " + srv.URL + " on=failed"); err != nil {
+		t.Fatal(err)
+	}
+	ev := &Event{Time: time.Now(), Service: "foo", Type: EventFailed, State: StateFailed, Err: "maximum retries reached"}
+	if !n.Wants(ev) {
+		t.Fatal("notifier should want failed events")
+	}
+	if n.Wants(&Event{Type: EventStarted}) {
+		t.Fatal("notifier should not want started events")
+	}
+	if err := n.Notify(ev); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 2 {
+		t.Errorf("expecting 2 requests, got %d", requests)
+	}
+	if len(received) != 1 {
+		t.Fatalf("expecting 1 notification, got %d", len(received))
+	}
+	if r := received[0]; r.Service != "foo" || r.Type != EventFailed || r.Err != ev.Err {
+		t.Errorf("unexpected notification %+v", r.Event)
+	}
+}
+
+func TestNotifierQueue(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		n := &notification{Event: &Event{}}
+		if err := json.NewDecoder(r.Body).Decode(n); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		received = append(received, n.Err)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	n := new(Notifier)
+	if err := n.Parse("webhook " + srv.URL + " retries=0"); err != nil {
+		t.Fatal(err)
+	}
+	// The first event is being delivered while the
+	// rest wait in the queue, which drops the last 5
+	total := notifyQueueSize + 6
+	for ii := 0; ii < total; ii++ {
+		n.enqueue(&Event{Time: time.Now(), Service: "foo", Type: EventFailed, Err: strconv.Itoa(ii)})
+		if ii == 0 {
+			for {
+				n.mu.Lock()
+				waiting := len(n.queue)
+				n.mu.Unlock()
+				if waiting == 0 {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+	}
+	close(release)
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		n.mu.Lock()
+		delivering := n.delivering
+		n.mu.Unlock()
+		if !delivering {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("notifications not delivered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(received) != notifyQueueSize+1 {
+		t.Fatalf("expecting %d notifications, got %d", notifyQueueSize+1, len(received))
+	}
+	for ii, v := range received {
+		if v != strconv.Itoa(ii) {
+			t.Fatalf("expecting notification %d, got %s", ii, v)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// USER_HZ, which is 100 on all the architectures
+	// supported by Linux.
+	clockTicks = 100
+)
+
+// procStat contains the fields we're interested in
+// from /proc/<pid>/stat. See proc(5).
+type procStat struct {
+	State      byte
+	Utime      uint64
+	Stime      uint64
+	NumThreads int
+	RSS        uint64 // in pages
+}
+
+// CPUTime returns the time spent by the process both in
+// user and kernel mode.
+func (p *procStat) CPUTime() time.Duration {
+	return time.Duration(p.Utime+p.Stime) * time.Second / clockTicks
+}
+
+// RSSBytes returns the resident set size of the process, in bytes.
+func (p *procStat) RSSBytes() uint64 {
+	return p.RSS * uint64(os.Getpagesize())
+}
+
+func readProcStat(pid int) (*procStat, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return nil, err
+	}
+	// The command name is enclosed in parens and might
+	// contain spaces or parens itself, so skip up to the
+	// last one.
+	s := string(data)
+	p := strings.LastIndexByte(s, ')')
+	if p < 0 {
+		return nil, fmt.Errorf("invalid stat for pid %d", pid)
+	}
+	fields := strings.Fields(s[p+1:])
+	// fields[0] is field number 3 in proc(5)
+	if len(fields) < 22 {
+		return nil, fmt.Errorf("invalid stat for pid %d: only %d fields", pid, len(fields))
+	}
+	st := &procStat{State: fields[0][0]}
+	if st.Utime, err = strconv.ParseUint(fields[11], 10, 64); err != nil {
+		return nil, err
+	}
+	if st.Stime, err = strconv.ParseUint(fields[12], 10, 64); err != nil {
+		return nil, err
+	}
+	if st.NumThreads, err = strconv.Atoi(fields[17]); err != nil {
+		return nil, err
+	}
+	if st.RSS, err = strconv.ParseUint(fields[21], 10, 64); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
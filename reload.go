@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gnd.la/log"
+)
+
+const (
+	defaultReconcileInterval = time.Minute
+)
+
+var (
+	// Altered during tests
+	configDebounce = 500 * time.Millisecond
+)
+
+// applyConfigLocked registers a service for the given configuration
+// or decides how to update the existing service with the same file.
+// It returns a message describing the action, or an empty string if
+// nothing changed. Since stopping and starting a service might take
+// several seconds, that's left to the returned function, which might
+// be nil and must be called after releasing g.mu.
+func (g *Governator) applyConfigLocked(cfg *Config) (string, func()) {
+	if _, s := g.serviceByFilenameLocked(cfg.File); s != nil {
+		if cfg.Err != nil && s.Config.Err == nil {
+			// Keep the last good configuration
+			if s.ConfigErr == nil || s.ConfigErr.Error() != cfg.Err.Error() {
+				s.errorf("error in configuration, keeping the last valid one: %s", cfg.Err)
+			}
+			s.ConfigErr = cfg.Err
+			return fmt.Sprintf("kept last valid configuration for %s: %s", s.Name(), cfg.Err), nil
+		}
+		s.ConfigErr = nil
+		// Keep the name given when the service was added, otherwise
+		// renamed duplicates would change on every reload
+		g.ensureUniqueName(cfg, s)
+		if len(diffConfigs(s.Config, cfg)) == 0 {
+			return "", nil
+		}
+		return fmt.Sprintf("updated %s", s.Name()), func() { g.updateService(s, cfg) }
+	}
+	name, err := g.addServiceLocked(cfg)
+	if err != nil {
+		return fmt.Sprintf("error adding service %s: %s", cfg.ServiceName(), err), nil
+	}
+	msg := fmt.Sprintf("added %s", name)
+	if !cfg.Start {
+		return msg, nil
+	}
+	s, _ := g.serviceByNameLocked(name)
+	return msg, func() {
+		log.Debugf("starting service %s", name)
+		s.Start()
+	}
+}
+
+// applyFileLocked applies the changes to the given file in the
+// services directory, which might have been added, changed
+// or removed. See applyConfigLocked for the return values.
+func (g *Governator) applyFileLocked(name string) (string, func()) {
+	if _, err := os.Stat(g.servicePath(name)); err != nil && os.IsNotExist(err) {
+		return g.removeConfigLocked(name)
+	}
+	if g.shouldIgnoreFile(name, false) {
+		return "", nil
+	}
+	return g.applyConfigLocked(g.parseConfig(name))
+}
+
+// removeConfigLocked removes the service registered for the given
+// configuration file. The returned function stops it and must be
+// called after releasing g.mu.
+func (g *Governator) removeConfigLocked(file string) (string, func()) {
+	if err := g.removeEnabled(file); err != nil {
+		log.Errorf("error removing enabled state for %s: %s", file, err)
+	}
+	ii, s := g.serviceByFilenameLocked(file)
+	if s == nil {
+		return "", nil
+	}
+	log.Debugf("removed service %s", s.Name())
+	g.services = append(g.services[:ii], g.services[ii+1:]...)
+	return fmt.Sprintf("removed %s", s.Name()), func() {
+		s.mu.Lock()
+		running := s.State.canStop()
+		s.mu.Unlock()
+		if running {
+			s.Stop()
+		} else {
+			s.stopWatchdog()
+		}
+	}
+}
+
+// updateService replaces the configuration of the given service,
+// restarting it if it's running. It must be called without g.mu
+// held, which is only taken to swap the configuration.
+func (g *Governator) updateService(s *Service, cfg *Config) {
+	log.Debugf("changed service %s's configuration", s.Name())
+	s.mu.Lock()
+	start := s.State == StateStarted
+	s.mu.Unlock()
+	if start {
+		start = s.Stop() == nil
+	} else {
+		// The watchdogs might still be open, e.g. after a stop
+		// failure action, and they're replaced with the config
+		s.stopWatchdog()
+	}
+	g.mu.Lock()
+	s.mu.Lock()
+	s.Config = cfg
+	s.mu.Unlock()
+	g.sortServices()
+	g.mu.Unlock()
+	if start {
+		s.Start()
+	}
+}
+
+// ReloadConfig rescans the services directory and reconciles
+// the registered services with the configuration files,
+// adding, updating and removing services as needed. It
+// returns a description of the performed actions. g.mu
+// is only held while deciding the actions, services are
+// started and stopped after releasing it.
+func (g *Governator) ReloadConfig() ([]string, error) {
+	configs, err := g.parseConfigs()
+	if err != nil {
+		return nil, err
+	}
+	g.reloadMu.Lock()
+	defer g.reloadMu.Unlock()
+	g.mu.Lock()
+	var actions []string
+	var pending []func()
+	apply := func(msg string, fn func()) {
+		if msg != "" {
+			actions = append(actions, msg)
+		}
+		if fn != nil {
+			pending = append(pending, fn)
+		}
+	}
+	files := make(map[string]bool)
+	for _, v := range configs {
+		files[v.File] = true
+		apply(g.applyConfigLocked(v))
+	}
+	var removed []string
+	for _, v := range g.services {
+		if !v.Config.transient && !files[v.Config.File] {
+			removed = append(removed, v.Config.File)
+		}
+	}
+	for _, v := range removed {
+		apply(g.removeConfigLocked(v))
+	}
+	g.mu.Unlock()
+	for _, fn := range pending {
+		fn()
+	}
+	return actions, nil
+}
+
+// startReconciling periodically reloads the configuration, to
+// catch any changes missed by the file watcher.
+func (g *Governator) startReconciling(interval time.Duration) {
+	q := newQuit()
+	ticker := time.NewTicker(interval)
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				actions, err := g.ReloadConfig()
+				if err != nil {
+					log.Errorf("error reconciling configuration: %s", err)
+					break
+				}
+				for _, v := range actions {
+					log.Infof("reconciling configuration: %s", v)
+				}
+			case <-q.stop:
+				ticker.Stop()
+				q.sendStopped()
+				return
+			}
+		}
+	}()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.quits = append(g.quits, q)
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeServiceFile(t *testing.T, g *Governator, name string, data string) {
+	if err := ioutil.WriteFile(g.servicePath(name), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "governator-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "services"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGovernator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(expect ...string) {
+		actions, err := g.ReloadConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(expect) == 0 && len(actions) == 0 {
+			return
+		}
+		if !reflect.DeepEqual(actions, expect) {
+			t.Errorf("expecting actions %q, got %q", expect, actions)
+		}
+	}
+	writeServiceFile(t, g, "foo", "command = sleep 50000\nstart = false\n")
+	check("added foo")
+	check()
+	writeServiceFile(t, g, "foo", "command = sleep 60000\nstart = false\n")
+	diffs, err := g.DiffConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || len(diffs[0].Changes) != 1 {
+		t.Fatalf("expecting one change, got %v", diffs)
+	}
+	if c := diffs[0].Changes[0]; c.Field != "Command" || c.Old != "sleep 50000" || c.New != "sleep 60000" {
+		t.Errorf("unexpected change %s", c)
+	}
+	check("updated foo")
+	writeServiceFile(t, g, "foo", "command = sleep 60000\nstart = false\nwatchdog = invalid\n")
+	diffs, err = g.DiffConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 1 || diffs[0].ParseErr == nil || !strings.Contains(diffs[0].String(), "parse error, keeping current config") {
+		t.Errorf("expecting a parse error keeping the current config, got %v", diffs)
+	}
+	actions, err := g.ReloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 1 || !strings.HasPrefix(actions[0], "kept last valid configuration for foo") {
+		t.Errorf("expecting last valid configuration to be kept, got %q", actions)
+	}
+	if s, _ := g.serviceByName("foo"); s == nil || s.ConfigErr == nil || s.Config.Err != nil {
+		t.Error("invalid configuration was applied")
+	}
+	if err := os.Remove(g.servicePath("foo")); err != nil {
+		t.Fatal(err)
+	}
+	check("removed foo")
+	if _, err := g.serviceByName("foo"); err == nil {
+		t.Error("service foo was not removed")
+	}
+}
+
+func TestReloadDuplicateNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "governator-reload-names")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "services"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGovernator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeServiceFile(t, g, "a", "name = same\ncommand = sleep 50000\nstart = false\n")
+	writeServiceFile(t, g, "b", "name = same\ncommand = sleep 50000\nstart = false\n")
+	actions, err := g.ReloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actions) != 2 {
+		t.Fatalf("expecting 2 services to be added, got %q", actions)
+	}
+	for ii := 0; ii < 2; ii++ {
+		if actions, err := g.ReloadConfig(); err != nil || len(actions) > 0 {
+			t.Errorf("expecting no changes after reloading, got %q (%v)", actions, err)
+		}
+		if diffs, err := g.DiffConfig(); err != nil || len(diffs) > 0 {
+			t.Errorf("expecting no differences after reloading, got %v (%v)", diffs, err)
+		}
+	}
+	for _, v := range []string{"same", "same-1"} {
+		if _, err := g.serviceByName(v); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestConfigDebounce(t *testing.T) {
+	old := configDebounce
+	configDebounce = 200 * time.Millisecond
+	defer func() { configDebounce = old }()
+	dir, err := ioutil.TempDir("", "governator-debounce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "services"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGovernator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.ReconcileInterval = 0
+	writeServiceFile(t, g, "foo", "command = sleep 50000\nlog = none\n")
+	if _, err := g.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	go g.Run()
+	defer g.StopRunning()
+	waitForStarted(t, g, "foo", 5*time.Second)
+	ch, cancel := g.Events("foo")
+	defer cancel()
+	// Simulate an editor writing the file several times
+	for ii := 1; ii <= 5; ii++ {
+		writeServiceFile(t, g, "foo", fmt.Sprintf("command = sleep %d\nlog = none\n", 50000+ii))
+		time.Sleep(50 * time.Millisecond)
+	}
+	restarts := 0
+	timeout := time.After(configDebounce + 3*time.Second)
+Wait:
+	for {
+		select {
+		case ev := <-ch:
+			if ev.Type == EventStarting {
+				restarts++
+			}
+		case <-timeout:
+			break Wait
+		}
+	}
+	if restarts != 1 {
+		t.Errorf("expecting 1 restart, got %d", restarts)
+	}
+	s, err := g.serviceByName("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Config.Command != "sleep 50005" {
+		t.Errorf("expecting last written command, got %q", s.Config.Command)
+	}
+}
+
+func TestConfigChangeUnlocked(t *testing.T) {
+	old := configDebounce
+	configDebounce = 100 * time.Millisecond
+	defer func() { configDebounce = old }()
+	dir, err := ioutil.TempDir("", "governator-unlocked")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "services"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGovernator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.ReconcileInterval = 0
+	// Ignores SIGTERM, so stopping it takes stop_timeout
+	const cfg = "command = sh -c \"trap '' TERM; while true; do sleep 1; done\"\nlog = none\nstop_timeout = 2\n"
+	go g.Run()
+	defer g.StopRunning()
+	// Let the watcher add the service, so it's known to be running
+	// when the file is changed
+	for ii := 0; ; ii++ {
+		writeServiceFile(t, g, "foo", cfg)
+		if _, s := g.serviceByFilename("foo"); s != nil {
+			break
+		}
+		if ii == 10 {
+			t.Fatal("service was not added")
+		}
+		time.Sleep(5 * configDebounce)
+	}
+	waitForStarted(t, g, "foo", 5*time.Second)
+	ch, cancel := g.Events("foo")
+	defer cancel()
+	writeServiceFile(t, g, "foo", cfg+"priority = 10\n")
+	timeout := time.After(configDebounce + 3*time.Second)
+	for {
+		select {
+		case ev := <-ch:
+			if ev.Type != EventStopping {
+				continue
+			}
+		case <-timeout:
+			t.Fatal("service was not restarted")
+		}
+		break
+	}
+	done := make(chan struct{})
+	go func() {
+		g.serviceByName("foo")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("daemon locked while restarting a service")
+	}
+	waitForStarted(t, g, "foo", 5*time.Second)
+}
+
+func TestDaemonConfigDefaults(t *testing.T) {
+	dir, err := ioutil.TempDir("", "governator-defaults")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "services"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(daemonConfigPath(dir), []byte("user = nobody\nlog = none\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGovernator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeServiceFile(t, g, "foo", "command = sleep 50000\n")
+	writeServiceFile(t, g, "bar", "command = sleep 50000\nuser = root\n")
+	if cfg := g.parseConfig("foo"); cfg.User != "nobody" || cfg.Log.input != "none" {
+		t.Errorf("expecting defaults user = nobody and log = none, got %q and %q", cfg.User, cfg.Log.input)
+	}
+	if cfg := g.parseConfig("bar"); cfg.User != "root" {
+		t.Errorf("expecting user = root, got %q", cfg.User)
+	}
+	// The daemon watchdog interval only applies when the service
+	// doesn't set one
+	if cfg := g.parseConfig("foo"); cfg.WatchdogInterval != defaultWatchdogInterval {
+		t.Errorf("expecting watchdog interval %d, got %d", defaultWatchdogInterval, cfg.WatchdogInterval)
+	}
+	if err := ioutil.WriteFile(daemonConfigPath(dir), []byte("user = nobody\nlog = none\nwatchdog_interval = 60\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.ReloadDaemonConfig(); err != nil {
+		t.Fatal(err)
+	}
+	writeServiceFile(t, g, "explicit", "command = sleep 50000\nwatchdog_interval = 300\n")
+	writeServiceFile(t, g, "negative", "command = sleep 50000\nwatchdog_interval = -1\n")
+	for name, interval := range map[string]int{"foo": 60, "explicit": 300, "negative": -1} {
+		if cfg := g.parseConfig(name); cfg.WatchdogInterval != interval {
+			t.Errorf("expecting watchdog interval %d for %s, got %d", interval, name, cfg.WatchdogInterval)
+		}
+	}
+	if err := ioutil.WriteFile(daemonConfigPath(dir), []byte("log = invalid\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.ReloadDaemonConfig(); err == nil {
+		t.Error("expecting an error with an invalid default logger")
+	}
+	if cfg := g.parseConfig("foo"); cfg.User != "nobody" {
+		t.Error("invalid daemon configuration was applied")
+	}
+}
+
+func TestDaemonConfigLogDir(t *testing.T) {
+	old := currentLogDir()
+	defer setLogDir(old)
+	dir, err := ioutil.TempDir("", "governator-logdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	g, err := NewGovernator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := filepath.Join(dir, "logs")
+	if err := ioutil.WriteFile(daemonConfigPath(dir), []byte("log_dir = "+logs+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// Reload while parsing loggers, like a SIGHUP received while
+	// the services are being reconciled
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for ii := 0; ii < 100; ii++ {
+			if err := new(Logger).Parse("file"); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	if err := g.ReloadDaemonConfig(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if d := currentLogDir(); d != logs {
+		t.Errorf("expecting log dir %q, got %q", logs, d)
+	}
+}
+
+func TestDiffRunning(t *testing.T) {
+	daemonDir, err := ioutil.TempDir("", "governator-diff-daemon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(daemonDir)
+	localDir, err := ioutil.TempDir("", "governator-diff-local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(localDir)
+	for _, v := range []string{daemonDir, localDir} {
+		if err := os.Mkdir(filepath.Join(v, "services"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g, err := NewGovernator(daemonDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.ReconcileInterval = 0
+	addr := "unix://" + filepath.Join(daemonDir, "governator.sock")
+	g.ServerAddr = addr
+	writeServiceFile(t, g, "foo", "command = sleep 50000\nstart = false\nlog = none\n")
+	writeServiceFile(t, g, "bar", "command = sleep 50000\nlog = none\n")
+	if _, err := g.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	go g.Run()
+	defer g.StopRunning()
+	waitForStarted(t, g, "bar", 5*time.Second)
+	for ii := 0; ; ii++ {
+		if _, err := queryCommand(addr, []string{"list"}); err == nil {
+			break
+		} else if ii == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	local, err := NewGovernator(localDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeServiceFile(t, local, "foo", "command = sleep 60000\nstart = false\nlog = none\n")
+	writeServiceFile(t, local, "baz", "command = sleep 50000\nlog = none\n")
+	diffs, err := local.DiffRunning(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, v := range diffs {
+		got = append(got, v.String())
+	}
+	sort.Strings(got)
+	expect := []string{
+		"bar: would be stopped and removed\n",
+		"baz: would be added and started\n",
+		"foo: would be updated\n    Command: \"sleep 50000\" -> \"sleep 60000\"\n",
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expecting diffs %q, got %q", expect, got)
+	}
+	// Comparing the daemon's own directory finds no changes
+	if diffs, err := g.DiffRunning(addr); err != nil || len(diffs) != 0 {
+		t.Errorf("expecting no changes, got %v, %v", diffs, err)
+	}
+}
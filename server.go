@@ -24,12 +24,12 @@ func (g *Governator) serveConn(conn net.Conn) error {
 		var st *Service
 		var name string
 		cmd := strings.ToLower(args[0])
-		if cmd == "start" || cmd == "stop" || cmd == "restart" || cmd == "log" {
+		if cmd == "start" || cmd == "stop" || cmd == "restart" || cmd == "log" || cmd == "status" || cmd == "check" {
 			if len(args) != 2 {
 				err = encodeResponse(conn, respErr, fmt.Sprintf("command %s requires exactly one argument\n", cmd))
 				cmd = ""
 			}
-			if cmd != "" && (cmd == "log" || args[1] != "all") {
+			if cmd != "" && (cmd == "log" || cmd == "status" || cmd == "check" || args[1] != "all") {
 				st, err = g.serviceByName(args[1])
 				if err != nil {
 					err = encodeResponse(conn, respErr, fmt.Sprintf("%s\n", err))
@@ -50,18 +50,22 @@ func (g *Governator) serveConn(conn net.Conn) error {
 			if st.State == StateStarted {
 				err = encodeResponse(conn, respOk, fmt.Sprintf("%s is already running\n", name))
 			} else {
-				err = g.startService(conn, st)
+				_, err = g.startService(conn, st)
 			}
 		case "stop":
 			if st == nil {
 				// all
 				g.stopServices(conn)
+				g.removeTransients()
 				break
 			}
 			if !st.State.canStop() {
 				err = encodeResponse(conn, respOk, fmt.Sprintf("%s is not running\n", name))
 			} else {
-				_, err = g.stopService(conn, st)
+				var stopped bool
+				if stopped, err = g.stopService(conn, st); stopped {
+					g.removeTransient(st)
+				}
 			}
 		case "restart":
 			if st == nil {
@@ -75,41 +79,128 @@ func (g *Governator) serveConn(conn net.Conn) error {
 				stopped, err = g.stopService(conn, st)
 			}
 			if stopped {
-				err = g.startService(conn, st)
+				_, err = g.startService(conn, st)
+			}
+		case "signal":
+			sargs := args[1:]
+			group := false
+			if len(sargs) > 0 && (sargs[0] == "-g" || sargs[0] == "--group") {
+				group = true
+				sargs = sargs[1:]
+			}
+			if len(sargs) != 2 {
+				err = encodeResponse(conn, respErr, "signal requires a service and a signal\n")
+				break
+			}
+			sig, serr := parseSignal(sargs[1])
+			if serr != nil {
+				err = encodeResponse(conn, respErr, fmt.Sprintf("%s\n", serr))
+				break
+			}
+			var services []*Service
+			if sargs[0] == "all" {
+				g.mu.Lock()
+				for _, v := range g.services {
+					if v.State.isRunState() {
+						services = append(services, v)
+					}
+				}
+				g.mu.Unlock()
+			} else {
+				s, serr := g.serviceByName(sargs[0])
+				if serr != nil {
+					err = encodeResponse(conn, respErr, fmt.Sprintf("%s\n", serr))
+					break
+				}
+				services = append(services, s)
+			}
+			for _, v := range services {
+				if serr := v.Signal(sig, group); serr != nil {
+					err = encodeResponse(conn, respErr, fmt.Sprintf("error sending %s to %s: %s\n", sig, v.Name(), serr))
+				} else {
+					err = encodeResponse(conn, respOk, fmt.Sprintf("sent %s to %s\n", sig, v.Name()))
+				}
+			}
+		case "reload-config":
+			actions, rerr := g.ReloadConfig()
+			if rerr != nil {
+				err = encodeResponse(conn, respErr, fmt.Sprintf("error reloading configuration: %s\n", rerr))
+				break
+			}
+			if len(actions) == 0 {
+				err = encodeResponse(conn, respOk, "no changes\n")
+				break
+			}
+			for _, v := range actions {
+				err = encodeResponse(conn, respOk, v+"\n")
+			}
+		case "diff":
+			diffs, derr := g.DiffConfig()
+			if derr != nil {
+				err = encodeResponse(conn, respErr, fmt.Sprintf("error comparing configuration: %s\n", derr))
+				break
+			}
+			if len(diffs) == 0 {
+				err = encodeResponse(conn, respOk, "no changes\n")
+				break
+			}
+			for _, v := range diffs {
+				err = encodeResponse(conn, respOk, v.String())
+			}
+		case "run":
+			cfg, rerr := parseRunArgs(args[1:])
+			if rerr != nil {
+				err = encodeResponse(conn, respErr, fmt.Sprintf("%s\n", rerr))
+				break
+			}
+			g.mu.Lock()
+			name, _ := g.addServiceLocked(cfg)
+			s, _ := g.serviceByNameLocked(name)
+			g.mu.Unlock()
+			_, err = g.startService(conn, s)
+		case "enable", "disable":
+			if len(args) != 2 {
+				err = encodeResponse(conn, respErr, fmt.Sprintf("command %s requires exactly one argument\n", cmd))
+				break
+			}
+			s, serr := g.serviceByName(args[1])
+			if serr != nil {
+				err = encodeResponse(conn, respErr, fmt.Sprintf("%s\n", serr))
+				break
+			}
+			enabled := cmd == "enable"
+			if serr := g.setEnabled(s, enabled); serr != nil {
+				err = encodeResponse(conn, respErr, fmt.Sprintf("error %sing %s: %s\n", cmd[:len(cmd)-1], s.Name(), serr))
+				break
 			}
+			err = encodeResponse(conn, respOk, fmt.Sprintf("%s %s\n", enabledString(enabled), s.Name()))
 		case "list":
 			var buf bytes.Buffer
 			w := tabwriter.NewWriter(&buf, 4, 4, 4, ' ', 0)
-			fmt.Fprint(w, "SERVICE\tSTATUS\t\n")
+			fmt.Fprint(w, "SERVICE\tBOOT\tSTATUS\t\n")
 			g.mu.Lock()
 			for _, v := range g.services {
-				fmt.Fprintf(w, "%s\t", v.Name())
-				switch v.State {
-				case StateStopped:
-					fmt.Fprint(w, "STOPPED")
-				case StateStopping:
-					fmt.Fprint(w, "STOPPING")
-				case StateStarting:
-					fmt.Fprint(w, "STARTING")
-				case StateStarted:
-					if v.Restarts > 0 {
-						fmt.Fprintf(w, "RUNNING since %s - %d restarts", formatTime(v.Started), v.Restarts)
-					} else {
-						fmt.Fprintf(w, "RUNNING since %s", formatTime(v.Started))
-					}
-				case StateBackoff:
-					fmt.Fprintf(w, "BACKOFF - %s - next retry in %s", v.Err, v.untilNextRestart())
-				case StateFailed:
-					fmt.Fprintf(w, "FAILED - %s", v.Err)
-				default:
-					panic("invalid state")
+				boot := enabledString(v.Config.Start)
+				if v.Config.transient {
+					boot = "transient"
+				}
+				fmt.Fprintf(w, "%s\t%s\t", v.Name(), boot)
+				v.mu.Lock()
+				fmt.Fprint(w, v.statusString())
+				if v.ConfigErr != nil {
+					fmt.Fprintf(w, " - configuration not applied: %s", v.ConfigErr)
 				}
+				v.mu.Unlock()
 				fmt.Fprint(w, "\t\n")
 			}
 			g.mu.Unlock()
 			w.Flush()
 			buf.WriteString("\n")
 			err = encodeResponse(conn, respOk, buf.String())
+		case "status":
+			err = encodeResponse(conn, respOk, serviceStatus(st))
+		case "check":
+			err = checkService(conn, st)
 		case "log":
 			if st.State != StateStarted {
 				err = encodeResponse(conn, respErr, fmt.Sprintf("%s is not running\n", name))
@@ -142,6 +233,25 @@ func (g *Governator) serveConn(conn net.Conn) error {
 			<-ch
 			st.Config.Log.Monitor = nil
 			return nil
+		case "events":
+			var services []string
+			asJSON := false
+			for _, v := range args[1:] {
+				if v == "-json" || v == "--json" {
+					asJSON = true
+					continue
+				}
+				if _, err = g.serviceByName(v); err != nil {
+					break
+				}
+				services = append(services, v)
+			}
+			if err != nil {
+				err = encodeResponse(conn, respErr, fmt.Sprintf("%s\n", err))
+				break
+			}
+			g.serveEvents(conn, services, asJSON)
+			return nil
 		case "conf":
 			if len(args) != 2 {
 				err = encodeResponse(conn, respErr, fmt.Sprintf("conf requires one argument, %d given", len(args)-1))
@@ -200,6 +310,39 @@ func (g *Governator) serveConn(conn net.Conn) error {
 	return encodeResponse(conn, respEnd, "")
 }
 
+// serveEvents streams events to the client until it sends
+// something or closes the connection. If asJSON is true,
+// events are sent JSON encoded, one per line.
+func (g *Governator) serveEvents(conn net.Conn, services []string, asJSON bool) {
+	ch, cancel := g.Events(services...)
+	defer cancel()
+	done := make(chan bool, 1)
+	go func() {
+		// events stop when the client sends something over the
+		// connection or the connection is closed
+		b := make([]byte, 1)
+		conn.Read(b)
+		conn.Close()
+		done <- true
+	}()
+	for {
+		select {
+		case ev := <-ch:
+			var s string
+			if asJSON {
+				s = ev.JSON()
+			} else {
+				s = ev.String()
+			}
+			if err := encodeResponse(conn, respOk, s+"\n"); err != nil {
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
 func (g *Governator) startServer() error {
 	q := newQuit()
 	scheme, addr, err := parseServerAddr(g.ServerAddr)
@@ -214,7 +357,7 @@ func (g *Governator) startServer() error {
 		return err
 	}
 	if scheme == "unix" {
-		if gid := getGroupId(AppName); gid >= 0 {
+		if gid := getGroupId(g.currentDaemonConfig().socketGroup()); gid >= 0 {
 			os.Chown(addr, 0, gid)
 			os.Chmod(addr, 0775)
 		}
@@ -253,3 +396,55 @@ func (g *Governator) startServer() error {
 	g.quits = append(g.quits, q)
 	return nil
 }
+
+// serviceStatus returns the detailed status of a service,
+// including the last results of its watchdogs.
+func serviceStatus(s *Service) string {
+	var buf bytes.Buffer
+	s.mu.Lock()
+	fmt.Fprintf(&buf, "%s - %s\n", s.Name(), s.statusString())
+	if s.State.isRunState() && s.Cmd != nil && s.Cmd.Process != nil {
+		fmt.Fprintf(&buf, "    pid: %d\n", s.Cmd.Process.Pid)
+	}
+	if s.ConfigErr != nil {
+		fmt.Fprintf(&buf, "    configuration not applied: %s\n", s.ConfigErr)
+	}
+	watchdogs := s.Config.watchdogs()
+	s.mu.Unlock()
+	for _, v := range watchdogs {
+		checks, failures, _ := v.Stats()
+		fmt.Fprintf(&buf, "    watchdog %s - %d checks, %d failures", v, checks, failures)
+		if interval := v.runInterval(); interval > 0 {
+			fmt.Fprintf(&buf, " - every %ds", interval)
+		}
+		buf.WriteByte('\n')
+		for _, r := range v.History() {
+			fmt.Fprintf(&buf, "        %s\n", &r)
+		}
+	}
+	return buf.String()
+}
+
+// checkService runs the watchdogs of the service immediately
+// and sends their results. Failures don't trigger any actions.
+func checkService(conn net.Conn, s *Service) error {
+	s.mu.Lock()
+	watchdogs := s.Config.watchdogs()
+	s.mu.Unlock()
+	if len(watchdogs) == 0 {
+		return encodeResponse(conn, respErr, fmt.Sprintf("%s has no watchdogs\n", s.Name()))
+	}
+	for _, v := range watchdogs {
+		res := v.runCheck(true)
+		var err error
+		if res.Err != nil {
+			err = encodeResponse(conn, respErr, fmt.Sprintf("watchdog %s: %s\n", v, &res))
+		} else {
+			err = encodeResponse(conn, respOk, fmt.Sprintf("watchdog %s: %s\n", v, &res))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runCheckService runs checkService and returns its responses.
+func runCheckService(t *testing.T, s *Service) ([]string, []resp) {
+	client, server := net.Pipe()
+	defer client.Close()
+	errCh := make(chan error, 1)
+	go func() {
+		err := checkService(server, s)
+		server.Close()
+		errCh <- err
+	}()
+	var lines []string
+	var types []resp
+	for {
+		r, line, err := decodeResponse(client)
+		if err != nil {
+			break
+		}
+		lines = append(lines, line)
+		types = append(types, r)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	return lines, types
+}
+
+func TestCheckService(t *testing.T) {
+	s := newService(&Config{Name: "check"})
+	lines, types := runCheckService(t, s)
+	if len(lines) != 1 || types[0] != respErr || lines[0] != "check has no watchdogs\n" {
+		t.Errorf("unexpected responses without watchdogs %q", lines)
+	}
+	ok := new(Watchdog)
+	if err := ok.Parse("run true"); err != nil {
+		t.Fatal(err)
+	}
+	failing := new(Watchdog)
+	if err := failing.Parse("run false"); err != nil {
+		t.Fatal(err)
+	}
+	failing.run()
+	s = newService(&Config{Name: "check", Watchdogs: []*Watchdog{ok, failing}})
+	lines, types = runCheckService(t, s)
+	if len(lines) != 2 {
+		t.Fatalf("expecting 2 responses, got %q", lines)
+	}
+	if types[0] != respOk || !strings.Contains(lines[0], "OK in") {
+		t.Errorf("unexpected response for passing watchdog %q", lines[0])
+	}
+	if types[1] != respErr || !strings.Contains(lines[1], "FAILED in") {
+		t.Errorf("unexpected response for failing watchdog %q", lines[1])
+	}
+	// Manual checks appear in the history, but not in the statistics
+	// used by the status command and the metrics
+	if checks, failures, _ := failing.Stats(); checks != 1 || failures != 1 {
+		t.Errorf("expecting 1 check and 1 failure, got %d and %d", checks, failures)
+	}
+	history := failing.History()
+	if len(history) != 2 || history[0].Manual || !history[1].Manual {
+		t.Errorf("unexpected history %v", history)
+	}
+	if !strings.Contains(serviceStatus(s), "(manual)") {
+		t.Errorf("manual check not marked in status %q", serviceStatus(s))
+	}
+}
+
+func TestRunTransient(t *testing.T) {
+	dir, err := ioutil.TempDir("", "governator-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Mkdir(filepath.Join(dir, "services"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	g, err := NewGovernator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.ReconcileInterval = 0
+	addr := "unix://" + filepath.Join(dir, "governator.sock")
+	g.ServerAddr = addr
+	go g.Run()
+	defer g.StopRunning()
+	for ii := 0; ; ii++ {
+		if _, err := queryCommand(addr, []string{"list"}); err == nil {
+			break
+		} else if ii == 50 {
+			t.Fatal(err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	ch, cancel := g.Events("transient")
+	defer cancel()
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := queryCommand(addr, []string{"run", "--name", "transient", "--", "sleep", "50000"})
+		errCh <- err
+	}()
+	// The daemon must answer while the transient service starts
+	for ev := range ch {
+		if ev.Type == EventStarting {
+			break
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		queryCommand(addr, []string{"list"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(500 * time.Millisecond):
+		t.Error("daemon locked while starting a transient service")
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := queryCommand(addr, []string{"stop", "transient"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.serviceByName("transient"); err == nil {
+		t.Error("transient service not removed after stopping it")
+	}
+}
@@ -5,9 +5,9 @@ import (
 	"math"
 	"os"
 	"os/exec"
-	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -18,6 +18,8 @@ var (
 	// avoids starting multiple services
 	// at the same time, to enforce resource limits
 	startLock sync.Mutex
+	// Altered during tests
+	healthyPollInterval = time.Second
 )
 
 type State uint8
@@ -31,6 +33,36 @@ const (
 	StateFailed
 )
 
+var stateNames = [...]string{
+	StateStopped:  "stopped",
+	StateStopping: "stopping",
+	StateStarted:  "started",
+	StateStarting: "starting",
+	StateBackoff:  "backoff",
+	StateFailed:   "failed",
+}
+
+func (s State) String() string {
+	if int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *State) UnmarshalText(b []byte) error {
+	for ii, v := range stateNames {
+		if v == string(b) {
+			*s = State(ii)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid state %q", string(b))
+}
+
 func (s State) isRunState() bool {
 	return s == StateStarted || s == StateStarting
 }
@@ -45,21 +77,34 @@ const (
 )
 
 type Service struct {
-	mu           sync.Mutex // protects access to the fields
-	st           sync.Mutex // prevents start/stop from running concurrently
-	Config       *Config
-	Cmd          *exec.Cmd
-	State        State
-	Started      time.Time
-	Restarts     int
-	Err          error
+	mu       sync.Mutex // protects access to the fields
+	st       sync.Mutex // prevents start/stop from running concurrently
+	Config   *Config
+	Cmd      *exec.Cmd
+	State    State
+	Started  time.Time
+	Restarts int
+	ExitCode int
+	Err      error
+	// ConfigErr is the error found when parsing the last
+	// changes to the configuration file, which were not
+	// applied. Config is the last valid configuration.
+	ConfigErr error
+	// Unhealthy is set by the unhealthy watchdog failure action
+	// and cleared when the watchdog recovers or the service is
+	// started again. HealthErr is the error which caused it.
+	Unhealthy    bool
+	HealthErr    error
 	stopCh       chan error
 	errCh        chan error
 	retries      int
 	startTimer   *time.Timer
 	nextStart    time.Time
 	monitor      *Monitor
+	events       *eventBus
 	startedTimer *time.Timer
+	// commands counts the Start and Stop calls, accessed atomically
+	commands uint32
 }
 
 func newService(cfg *Config) *Service {
@@ -93,6 +138,24 @@ func (s *Service) sendErr(ch *chan<- error, err error) {
 func (s *Service) Start() error {
 	s.st.Lock()
 	defer s.st.Unlock()
+	return s.startLocked()
+}
+
+// startUnlessChanged works like Start, but it skips the service when
+// it has been started or stopped since commandCount returned count.
+// This avoids undoing the commands received while services are being
+// started from a snapshot. It returns whether the service was skipped.
+func (s *Service) startUnlessChanged(count uint32) (bool, error) {
+	s.st.Lock()
+	defer s.st.Unlock()
+	if s.commandCount() != count {
+		return true, nil
+	}
+	return false, s.startLocked()
+}
+
+func (s *Service) startLocked() error {
+	atomic.AddUint32(&s.commands, 1)
 	if s.State.isRunState() {
 		return nil
 	}
@@ -110,6 +173,12 @@ func (s *Service) Start() error {
 	return nil
 }
 
+// commandCount returns the number of times the service
+// has been requested to start or stop.
+func (s *Service) commandCount() uint32 {
+	return atomic.LoadUint32(&s.commands)
+}
+
 func (s *Service) startIn(d time.Duration) {
 	s.stopTimer()
 	s.startTimer = time.AfterFunc(d, func() {
@@ -133,11 +202,39 @@ func (s *Service) startFailed(ch *chan<- error, err error) {
 		s.startIn(duration)
 		s.retries++
 		s.infof("will retry in %s", duration)
+		s.emit(EventBackoff, err)
 	} else {
 		s.State = StateFailed
 		s.Cmd = nil
 		s.errorf("maximum retries reached")
+		s.emit(EventFailed, err)
+	}
+}
+
+// statusString returns the state of the service, as
+// shown by the list and status commands.
+func (s *Service) statusString() string {
+	switch s.State {
+	case StateStopped:
+		return "STOPPED"
+	case StateStopping:
+		return "STOPPING"
+	case StateStarting:
+		return "STARTING"
+	case StateStarted:
+		if s.Unhealthy {
+			return fmt.Sprintf("UNHEALTHY - %s - running since %s", s.HealthErr, formatTime(s.Started))
+		}
+		if s.Restarts > 0 {
+			return fmt.Sprintf("RUNNING since %s - %d restarts", formatTime(s.Started), s.Restarts)
+		}
+		return fmt.Sprintf("RUNNING since %s", formatTime(s.Started))
+	case StateBackoff:
+		return fmt.Sprintf("BACKOFF - %s - next retry in %s", s.Err, s.untilNextRestart())
+	case StateFailed:
+		return fmt.Sprintf("FAILED - %s", s.Err)
 	}
+	panic("invalid state")
 }
 
 func (s *Service) untilNextRestart() time.Duration {
@@ -147,20 +244,35 @@ func (s *Service) untilNextRestart() time.Duration {
 func (s *Service) Run(ch chan<- error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	s.State = StateStarted
-	if s.State != StateStarted {
-		s.sendErr(&ch, nil)
-		return
-	}
+	s.State = StateStarting
 	cmd, err := s.Config.Cmd()
 	if err != nil {
 		s.State = StateFailed
 		s.sendErr(&ch, fmt.Errorf("could not initialize service: %s", err))
+		s.emit(EventFailed, s.Err)
 		return
 	}
+	for _, v := range s.Config.watchdogs() {
+		if ed, ok := v.dog.(envDog); ok {
+			env, err := ed.env()
+			if err != nil {
+				s.errorf("error preparing watchdog %s: %s", v, err)
+				continue
+			}
+			cmd.Env = append(cmd.Env, env...)
+		}
+	}
 	s.Cmd = cmd
 	s.Started = time.Now()
+	s.Unhealthy = false
+	s.HealthErr = nil
+	// Give the process InitialDelay seconds before checking it, including
+	// when it's restarted after exiting or by a failure action.
+	for _, v := range s.Config.watchdogs() {
+		v.delayChecks()
+	}
 	s.infof("starting")
+	s.emit(EventStarting, nil)
 	if err != nil {
 		s.errorf("error setting service limits: %s", err)
 	}
@@ -191,12 +303,17 @@ func (s *Service) afterStarted(ch *chan<- error) {
 	// Clear any potentially stored errors
 	s.started(ch)
 	s.infof("started")
+	s.emit(EventStarted, nil)
 }
 
 func (s *Service) exited(ch *chan<- error) func(error) {
 	return func(err error) {
 		s.mu.Lock()
 		defer s.mu.Unlock()
+		s.ExitCode = 0
+		if es, ok := err.(exitStatusError); ok {
+			s.ExitCode = int(es)
+		}
 		if s.startedTimer != nil {
 			// Consider failure, no mintime has passed
 			s.startedTimer.Stop()
@@ -217,6 +334,7 @@ func (s *Service) exited(ch *chan<- error) func(error) {
 		} else {
 			s.infof("exited without error - restarting")
 		}
+		s.emit(EventExited, err)
 		// Spawn a goroutine so this function ends and
 		// the lock is released before Run() is executed
 		// again.
@@ -227,6 +345,7 @@ func (s *Service) exited(ch *chan<- error) func(error) {
 func (s *Service) Stop() error {
 	s.st.Lock()
 	defer s.st.Unlock()
+	atomic.AddUint32(&s.commands, 1)
 	s.stopWatchdog()
 	if err := s.stopService(); err != nil {
 		s.startWatchdog()
@@ -235,24 +354,111 @@ func (s *Service) Stop() error {
 	return nil
 }
 
-func (s *Service) startWatchdog() error {
+// Signal sends the given signal to the service main process or,
+// if group is true, to its whole process group.
+func (s *Service) Signal(sig syscall.Signal, group bool) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.Config.Watchdog != nil {
-		interval := s.Config.WatchdogInterval
-		if interval < 0 {
-			interval = defaultWatchdogInterval
+	if !s.State.isRunState() || s.Cmd == nil || s.Cmd.Process == nil {
+		return fmt.Errorf("%s is not running", s.Name())
+	}
+	pid := s.Cmd.Process.Pid
+	if group {
+		pgid, err := syscall.Getpgid(pid)
+		if err != nil {
+			return err
+		}
+		if pgid != pid {
+			// Never signal the group the daemon belongs to
+			return fmt.Errorf("%s does not lead its own process group", s.Name())
+		}
+		return syscall.Kill(-pgid, sig)
+	}
+	return s.Cmd.Process.Signal(sig)
+}
+
+// setUnhealthy marks the service as unhealthy because of
+// the given error or, if err is nil, as healthy.
+func (s *Service) setUnhealthy(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil && s.Unhealthy {
+		s.infof("healthy again")
+	}
+	s.Unhealthy = err != nil
+	s.HealthErr = err
+}
+
+// waitHealthy waits until the service is running and all its
+// watchdogs pass, checking every healthyPollInterval. If the
+// deadline expires, the last error is returned. Closing abort
+// stops waiting.
+func (s *Service) waitHealthy(deadline time.Time, abort <-chan struct{}) error {
+	for {
+		err := s.healthCheck()
+		if err == nil || time.Now().Add(healthyPollInterval).After(deadline) {
+			return err
+		}
+		select {
+		case <-abort:
+			return errShuttingDown
+		case <-time.After(healthyPollInterval):
+		}
+	}
+}
+
+func (s *Service) healthCheck() error {
+	s.mu.Lock()
+	state := s.State
+	watchdogs := s.Config.watchdogs()
+	s.mu.Unlock()
+	if state != StateStarted {
+		return fmt.Errorf("service is %s", state)
+	}
+	for _, v := range watchdogs {
+		if err := v.run(); err != nil {
+			return err
 		}
-		return s.Config.Watchdog.Start(s, interval)
 	}
 	return nil
 }
 
-func (s *Service) stopWatchdog() {
+// pid returns the pid of the service main process,
+// or 0 if it's not running.
+func (s *Service) pid() int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if s.Config.Watchdog != nil {
-		s.Config.Watchdog.Stop()
+	if s.State.isRunState() && s.Cmd != nil && s.Cmd.Process != nil {
+		return s.Cmd.Process.Pid
+	}
+	return 0
+}
+
+// startWatchdog starts the watchdog loops. s.mu must not be held
+// while starting or stopping them, because stopping waits for the
+// loop, which might be running a failure action that locks s.mu.
+func (s *Service) startWatchdog() error {
+	s.mu.Lock()
+	interval := s.Config.WatchdogInterval
+	watchdogs := s.Config.watchdogs()
+	s.mu.Unlock()
+	if interval <= 0 {
+		interval = defaultWatchdogInterval
+	}
+	for _, v := range watchdogs {
+		if err := v.Start(s, interval); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Service) stopWatchdog() {
+	s.mu.Lock()
+	watchdogs := s.Config.watchdogs()
+	s.mu.Unlock()
+	for _, v := range watchdogs {
+		v.Stop()
 	}
 }
 
@@ -283,31 +489,34 @@ func (s *Service) stopService() error {
 	s.stopTimer()
 	s.mu.Lock()
 	if !s.State.isRunState() {
-		if s.State.canStop() {
+		prevState := s.State
+		s.State = StateStopped
+		if prevState.canStop() {
 			s.infof("stopped")
+			s.emit(EventStopped, nil)
 		}
-		s.State = StateStopped
 		s.mu.Unlock()
 		return nil
 	}
 	prevState := s.State
 	s.State = StateStopping
 	s.infof("stopping")
+	s.emit(EventStopping, nil)
 	p := s.Cmd.Process
 	s.mu.Unlock()
 	if s != nil {
-		stopped := isStoppedErr(p.Signal(os.Signal(syscall.SIGTERM)))
+		stopped := isStoppedErr(s.signalProcess(p, syscall.SIGTERM))
 		if !stopped {
 			select {
 			case <-s.stopCh:
 				stopped = true
-			case <-time.After(10 * time.Second):
-				stopped = isStoppedErr(p.Kill())
+			case <-time.After(s.Config.stopTimeout()):
+				stopped = isStoppedErr(s.signalProcess(p, syscall.SIGKILL))
 			}
 			if !stopped {
 				select {
 				case <-s.stopCh:
-				case <-time.After(2 * time.Second):
+				case <-time.After(s.Config.killTimeout()):
 					// sending signal 0 checks that the process is
 					// alive and we're allowed to send the signal
 					// without actually sending anything
@@ -327,6 +536,7 @@ func (s *Service) stopService() error {
 	s.mu.Lock()
 	s.State = StateStopped
 	s.Restarts = 0
+	s.emit(EventStopped, nil)
 	s.mu.Unlock()
 	if s.Config.Log != nil {
 		s.Config.Log.Close()
@@ -335,6 +545,36 @@ func (s *Service) stopService() error {
 	return nil
 }
 
+// emit publishes an event for this service, if the
+// service has been registered with an event bus.
+func (s *Service) emit(t EventType, err error) {
+	if s.events == nil {
+		return
+	}
+	ev := &Event{
+		Time:    time.Now(),
+		Service: s.Name(),
+		Type:    t,
+		State:   s.State,
+	}
+	if err != nil {
+		ev.Err = err.Error()
+	}
+	s.events.Publish(ev)
+}
+
+// signalProcess sends a signal to the service process. Services run
+// by the shell are signaled as a process group, since the shell
+// might not exec() the command.
+func (s *Service) signalProcess(p *os.Process, sig syscall.Signal) error {
+	if s.Config.Shell {
+		if err := syscall.Kill(-p.Pid, sig); err != syscall.ESRCH {
+			return err
+		}
+	}
+	return p.Signal(os.Signal(sig))
+}
+
 func (s *Service) log(level log.LLevel, prefix string, format string, args ...interface{}) {
 	msg := fmt.Sprintf(format, args...)
 	log.Logf(level, "[%s] %s", s.Name(), msg)
@@ -355,22 +595,6 @@ func (s *Service) debugf(format string, args ...interface{}) {
 	s.log(log.LDebug, "debug", format, args...)
 }
 
-func (s *Service) updateConfig(cfg *Config) {
-	if reflect.DeepEqual(s.Config, cfg) {
-		// there were changes to the file which don't affect the conf
-		return
-	}
-	log.Debugf("changed service %s's configuration", s.Name())
-	start := false
-	if s.State == StateStarted {
-		start = s.Stop() == nil
-	}
-	s.Config = cfg
-	if start {
-		s.Start()
-	}
-}
-
 func isStoppedErr(err error) bool {
 	return err != nil && strings.Contains(err.Error(), "process already finished")
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -116,7 +118,8 @@ func TestService(t *testing.T) {
 	s.mu.Lock()
 	state := s.State
 	s.mu.Unlock()
-	if state != StateStarted {
+	// It might be starting again already
+	if !state.isRunState() {
 		t.Fatal("service is not started")
 	}
 	time.Sleep(1 * time.Second)
@@ -272,3 +275,311 @@ func TestNumGoroutines(t *testing.T) {
 func init() {
 	logDir = "/tmp/governator"
 }
+
+func TestServiceEvents(t *testing.T) {
+	g := prepareGovernatorTest(t)
+	defer afterGovernatorTest(t, g)
+	cfg := &Config{
+		File:    "/non-existant",
+		Command: "sleep 50000",
+		Name:    "sleep-events",
+	}
+	name, err := g.AddService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, cancel := g.Events(name)
+	defer cancel()
+	if err := g.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Stop(name); err != nil {
+		t.Fatal(err)
+	}
+	expect := []struct {
+		typ   EventType
+		state State
+	}{
+		{EventStarting, StateStarting},
+		{EventStarted, StateStarted},
+		{EventStopping, StateStopping},
+		{EventStopped, StateStopped},
+	}
+	for _, v := range expect {
+		select {
+		case ev := <-ch:
+			if ev.Service != name {
+				t.Errorf("expecting event for service %s, got %s", name, ev.Service)
+			}
+			if ev.Type != v.typ {
+				t.Errorf("expecting event %s, got %s", v.typ, ev.Type)
+			}
+			if ev.State != v.state {
+				t.Errorf("expecting state %s with event %s, got %s", v.state, ev.Type, ev.State)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %s", v.typ)
+		}
+	}
+}
+
+func TestServiceSignal(t *testing.T) {
+	for _, v := range []string{"HUP", "sighup", "SIGHUP", "1"} {
+		sig, err := parseSignal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig != syscall.SIGHUP {
+			t.Errorf("expecting %s for %q, got %s", syscall.SIGHUP, v, sig)
+		}
+	}
+	if _, err := parseSignal("SIGFOO"); err == nil {
+		t.Error("expecting an error for SIGFOO")
+	}
+	g := prepareGovernatorTest(t)
+	defer afterGovernatorTest(t, g)
+	cfg := &Config{
+		File:    "/non-existant",
+		Command: "sleep 50000",
+		Name:    "sleep-signal",
+	}
+	name, err := g.AddService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := g.serviceByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Signal(syscall.SIGCONT, false); err == nil {
+		t.Error("expecting an error when signaling a stopped service")
+	}
+	if err := g.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop(name)
+	for _, group := range []bool{false, true} {
+		if err := s.Signal(syscall.SIGCONT, group); err != nil {
+			t.Errorf("error signaling service (group = %v): %s", group, err)
+		}
+	}
+}
+
+func TestTransientService(t *testing.T) {
+	g := prepareGovernatorTest(t)
+	defer afterGovernatorTest(t, g)
+	cfg, err := parseRunArgs([]string{"--name", "transient", "--env", "FOO=bar", "--", "sleep", "50000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setLogger(t, cfg, "none")
+	if cfg.Env["FOO"] != "bar" {
+		t.Errorf("expecting FOO=bar in environment, got %v", cfg.Env)
+	}
+	name, err := g.AddService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Stop(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.serviceByName(name); err == nil {
+		t.Error("transient service was not removed after stopping")
+	}
+	if _, err := parseRunArgs([]string{"--name", "foo"}); err == nil {
+		t.Error("expecting an error without a command")
+	}
+}
+
+func TestShellService(t *testing.T) {
+	g := prepareGovernatorTest(t)
+	defer afterGovernatorTest(t, g)
+	cfg := &Config{
+		File:    "/non-existant",
+		Command: "sleep 50000 | cat",
+		Name:    "sleep-shell",
+		Shell:   true,
+	}
+	name, err := g.AddService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := g.Stop(name); err != nil {
+		t.Fatal(err)
+	}
+	// The whole pipeline should receive SIGTERM, so
+	// there's no need to wait for the kill timeout.
+	if elapsed := time.Since(start); elapsed >= cfg.stopTimeout() {
+		t.Errorf("stopping shell service took %s", elapsed)
+	}
+}
+
+// newGovernatorWithServices adds the services before running the
+// governator, so they're started like they would be when the daemon
+// starts.
+func newGovernatorWithServices(t *testing.T, cfgs ...*Config) *Governator {
+	g, err := NewGovernator("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range cfgs {
+		setLogger(t, v, "none")
+		if _, err := g.AddService(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return g
+}
+
+func waitForStarted(t *testing.T, g *Governator, name string, timeout time.Duration) *Service {
+	s, err := g.serviceByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		s.mu.Lock()
+		state := s.State
+		s.mu.Unlock()
+		if state == StateStarted {
+			return s
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("%s not started after %s", name, timeout)
+	return nil
+}
+
+func TestWaitHealthy(t *testing.T) {
+	old := healthyPollInterval
+	healthyPollInterval = 100 * time.Millisecond
+	defer func() { healthyPollInterval = old }()
+	dir, err := ioutil.TempDir("", "governator-wait-healthy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ready := filepath.Join(dir, "ready")
+	wd := new(Watchdog)
+	if err := wd.Parse("file " + ready + " 60"); err != nil {
+		t.Fatal(err)
+	}
+	g := newGovernatorWithServices(t, &Config{
+		File:        "/non-existant",
+		Command:     "sleep 2; touch " + ready + " && exec sleep 50000",
+		Shell:       true,
+		Name:        "db",
+		Start:       true,
+		Priority:    1,
+		Watchdog:    wd,
+		WaitHealthy: true,
+	}, &Config{
+		File:     "/non-existant",
+		Command:  "sleep 50000",
+		Name:     "app",
+		Start:    true,
+		Priority: 2,
+	})
+	go g.Run()
+	defer afterGovernatorTest(t, g)
+	s := waitForStarted(t, g, "app", 10*time.Second)
+	info, err := os.Stat(ready)
+	if err != nil {
+		t.Fatalf("next tier started before db was healthy: %s", err)
+	}
+	if s.Started.Before(info.ModTime()) {
+		t.Errorf("app started at %s, before db was healthy at %s", s.Started, info.ModTime())
+	}
+}
+
+func TestWaitHealthyTimeout(t *testing.T) {
+	var cfgs []*Config
+	for ii := 0; ii < 3; ii++ {
+		wd := new(Watchdog)
+		if err := wd.Parse("file /non-existant 60"); err != nil {
+			t.Fatal(err)
+		}
+		cfgs = append(cfgs, &Config{
+			File:        "/non-existant",
+			Command:     "sleep 50000",
+			Name:        fmt.Sprintf("unhealthy-%d", ii),
+			Start:       true,
+			Priority:    1,
+			Watchdog:    wd,
+			WaitHealthy: true,
+		})
+	}
+	cfgs = append(cfgs, &Config{
+		File:     "/non-existant",
+		Command:  "sleep 50000",
+		Name:     "next",
+		Start:    true,
+		Priority: 2,
+	})
+	g := newGovernatorWithServices(t, cfgs...)
+	g.setDaemonConfig(&daemonConfig{TierTimeout: 1})
+	start := time.Now()
+	go g.Run()
+	defer afterGovernatorTest(t, g)
+	// Services in the same tier are started and wait in parallel
+	waitForStarted(t, g, "next", 4*time.Second)
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("next tier started after %s, before the tier timeout", elapsed)
+	}
+}
+
+func TestWaitHealthyWithoutWatchdogs(t *testing.T) {
+	old := healthyPollInterval
+	healthyPollInterval = 100 * time.Millisecond
+	defer func() { healthyPollInterval = old }()
+	g := prepareGovernatorTest(t)
+	defer afterGovernatorTest(t, g)
+	cfg := &Config{
+		File:    "/non-existant",
+		Command: "sleep 50000",
+		Name:    "sleep-healthy",
+	}
+	setLogger(t, cfg, "none")
+	name, err := g.AddService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := g.serviceByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Not running, must fail when the deadline expires
+	start := time.Now()
+	checkExpectedErr(t, s.waitHealthy(start.Add(500*time.Millisecond), nil), "service is stopped")
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("waiting for a stopped service took %s", elapsed)
+	}
+	if err := g.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop(name)
+	// Without watchdogs, running is healthy
+	if err := s.waitHealthy(time.Now().Add(time.Second), nil); err != nil {
+		t.Errorf("expecting running service without watchdogs to be healthy, got %s", err)
+	}
+	// Aborting stops waiting before the deadline
+	abort := make(chan struct{})
+	close(abort)
+	if err := g.Stop(name); err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	if err := s.waitHealthy(start.Add(time.Minute), abort); err != errShuttingDown {
+		t.Errorf("expecting %v after aborting, got %v", errShuttingDown, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("aborted wait took %s", elapsed)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The state directory holds settings changed at runtime which
+// must persist across daemon restarts, without modifying the
+// service configuration files. Each service which has been
+// explicitly enabled or disabled has a <file>.enabled file,
+// containing either true or false.
+
+func (g *Governator) stateDir() string {
+	return filepath.Join(g.configDir, "state")
+}
+
+func (g *Governator) enabledPath(file string) string {
+	return filepath.Join(g.stateDir(), file+".enabled")
+}
+
+// enabledOverride returns the persisted enabled state for
+// the given service file. The second return value is false
+// when the service hasn't been enabled nor disabled.
+func (g *Governator) enabledOverride(file string) (bool, bool) {
+	if g.configDir == "" || file == "" {
+		return false, false
+	}
+	data, err := ioutil.ReadFile(g.enabledPath(file))
+	if err != nil {
+		return false, false
+	}
+	enabled, err := strconv.ParseBool(strings.TrimSpace(string(data)))
+	if err != nil {
+		return false, false
+	}
+	return enabled, true
+}
+
+// setEnabled persists whether the service should be started
+// when the daemon starts, overriding its Start setting.
+func (g *Governator) setEnabled(s *Service, enabled bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	file := s.Config.File
+	if g.configDir == "" || file == "" {
+		return fmt.Errorf("%s has no configuration file", s.Name())
+	}
+	dir := g.stateDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Write to a temporary file and then rename it, so
+	// the state is never left half written.
+	tmp, err := ioutil.TempFile(dir, ".enabled")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(strconv.FormatBool(enabled) + "\n"); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), g.enabledPath(file)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	s.Config.Start = enabled
+	return nil
+}
+
+// removeEnabled removes the persisted enabled state for the
+// given service file, so it doesn't apply to a service file
+// created later with the same name.
+func (g *Governator) removeEnabled(file string) error {
+	if g.configDir == "" || file == "" {
+		return nil
+	}
+	if err := os.Remove(g.enabledPath(file)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func enabledString(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newStateTestGovernator(t *testing.T) (*Governator, string) {
+	dir, err := ioutil.TempDir("", "governator-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "services"), 0755); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	g, err := NewGovernator(dir)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return g, dir
+}
+
+func TestEnabledPersistence(t *testing.T) {
+	g, dir := newStateTestGovernator(t)
+	defer os.RemoveAll(dir)
+	writeServiceFile(t, g, "foo", "command = sleep 50000\nlog = none\n")
+	if _, ok := g.enabledOverride("foo"); ok {
+		t.Fatal("foo shouldn't have an enabled state")
+	}
+	s := newService(g.parseConfig("foo"))
+	if err := g.setEnabled(s, false); err != nil {
+		t.Fatal(err)
+	}
+	if s.Config.Start {
+		t.Error("disabling foo should clear Start")
+	}
+	if enabled, ok := g.enabledOverride("foo"); !ok || enabled {
+		t.Errorf("expecting foo to be disabled, got %v, %v", enabled, ok)
+	}
+	// A new daemon reading the same directory must see the override
+	g2, err := NewGovernator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg := g2.parseConfig("foo"); cfg.Start {
+		t.Error("disabled state not applied after restarting the daemon")
+	}
+	if err := g.setEnabled(s, true); err != nil {
+		t.Fatal(err)
+	}
+	if cfg := g2.parseConfig("foo"); !cfg.Start {
+		t.Error("enabled state not applied after restarting the daemon")
+	}
+	// Services without a configuration file can't be enabled
+	if err := g.setEnabled(newService(&Config{Name: "bar"}), true); err == nil {
+		t.Error("expecting an error enabling a service without a file")
+	}
+}
+
+func TestEnabledAtBoot(t *testing.T) {
+	g, dir := newStateTestGovernator(t)
+	defer os.RemoveAll(dir)
+	writeServiceFile(t, g, "on", "command = sleep 50000\nlog = none\n")
+	writeServiceFile(t, g, "off", "command = sleep 50000\nstart = false\nlog = none\n")
+	for name, enabled := range map[string]bool{"on": false, "off": true} {
+		if err := g.setEnabled(newService(g.parseConfig(name)), enabled); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Boot a new daemon, the overrides must win over the
+	// start setting in the service files
+	g2, err := NewGovernator(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g2.ReconcileInterval = 0
+	if _, err := g2.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	go g2.Run()
+	defer g2.StopRunning()
+	waitForStarted(t, g2, "off", 5*time.Second)
+	s, err := g2.serviceByName("on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	state := s.State
+	s.mu.Unlock()
+	if state != StateStopped {
+		t.Errorf("expecting disabled service to be stopped, it's %s", state)
+	}
+}
+
+func TestRemoveEnabled(t *testing.T) {
+	g, dir := newStateTestGovernator(t)
+	defer os.RemoveAll(dir)
+	writeServiceFile(t, g, "foo", "command = sleep 50000\nstart = false\nlog = none\n")
+	if _, err := g.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := g.serviceByName("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.setEnabled(s, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(g.servicePath("foo")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(g.enabledPath("foo")); !os.IsNotExist(err) {
+		t.Errorf("enabled state left behind after removing foo: %v", err)
+	}
+	// A new service file with the same name starts with its own setting
+	writeServiceFile(t, g, "foo", "command = sleep 50000\nstart = false\nlog = none\n")
+	if _, err := g.ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = g.serviceByName("foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Config.Start {
+		t.Error("stale enabled state applied to the new foo")
+	}
+}
@@ -3,7 +3,17 @@
 package main
 
 import (
+	"errors"
+	"net"
 	"syscall"
 )
 
 func prepareSysProcAttr(attr *syscall.SysProcAttr) {}
+
+func enableCredentials(conn *net.UnixConn) error {
+	return errors.New("sender credentials are not supported on this platform")
+}
+
+func credentialsBuffer() []byte { return nil }
+
+func credentialsPid(oob []byte) (int, bool) { return 0, false }
@@ -1,9 +1,47 @@
 package main
 
 import (
+	"net"
 	"syscall"
 )
 
 func prepareSysProcAttr(attr *syscall.SysProcAttr) {
 	attr.Pdeathsig = syscall.SIGQUIT // Send SIGQUIT to children if parent exits
 }
+
+// enableCredentials makes the kernel attach the credentials
+// of the sender to the messages received by conn.
+func enableCredentials(conn *net.UnixConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_PASSCRED, 1)
+	}); err != nil {
+		return err
+	}
+	return serr
+}
+
+// credentialsBuffer returns a buffer large enough for
+// receiving the credentials of the sender.
+func credentialsBuffer() []byte {
+	return make([]byte, syscall.CmsgSpace(syscall.SizeofUcred))
+}
+
+// credentialsPid returns the pid of the sender from the
+// control messages received along a message.
+func credentialsPid(oob []byte) (int, bool) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for ii := range msgs {
+		if cred, err := syscall.ParseUnixCredentials(&msgs[ii]); err == nil {
+			return int(cred.Pid), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+)
+
+// tlsOptions are the TLS settings shared by the watchdogs
+// which support TLS. They're given as the options:
+//
+//	ca=<file> insecure=<bool> cert=<file> key=<file>
+type tlsOptions struct {
+	ca       string
+	insecure bool
+	cert     string
+	key      string
+}
+
+// parseOption sets the option with the given key, returning
+// false if the key is not a TLS option.
+func (o *tlsOptions) parseOption(key string, value string) (bool, error) {
+	switch key {
+	case "ca":
+		o.ca = value
+	case "insecure":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return true, fmt.Errorf("invalid insecure value %q, must be a boolean", value)
+		}
+		o.insecure = b
+	case "cert":
+		o.cert = value
+	case "key":
+		o.key = value
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// config returns the *tls.Config for the options, or nil
+// if no options were set.
+func (o *tlsOptions) config() (*tls.Config, error) {
+	if o.ca == "" && !o.insecure && o.cert == "" && o.key == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{InsecureSkipVerify: o.insecure}
+	if o.ca != "" {
+		data, err := ioutil.ReadFile(o.ca)
+		if err != nil {
+			return nil, fmt.Errorf("error reading CA: %s", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in CA %s", o.ca)
+		}
+		cfg.RootCAs = pool
+	}
+	if o.cert != "" || o.key != "" {
+		if o.cert == "" || o.key == "" {
+			return nil, fmt.Errorf("client certificates require both cert and key")
+		}
+		cert, err := tls.LoadX509KeyPair(o.cert, o.key)
+		if err != nil {
+			return nil, fmt.Errorf("error loading client certificate: %s", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// parseRunArgs builds the configuration for a transient service
+// from the arguments to the run command, which are:
+//
+//	run [--name name] [--env K=V]... [--dir dir] [--user user] [--keep] -- cmd [args...]
+//
+// Transient services are not backed by a configuration file and
+// are removed when stopped, unless --keep is given, in which case
+// they're kept until the daemon exits.
+func parseRunArgs(args []string) (*Config, error) {
+	cfg := &Config{
+		Start:     true,
+		Priority:  1000,
+		transient: true,
+	}
+	for len(args) > 0 {
+		arg := args[0]
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		if !strings.HasPrefix(arg, "-") {
+			// No --, first non-flag argument starts the command
+			args = append([]string{arg}, args...)
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		var value string
+		if p := strings.IndexByte(name, '='); p >= 0 {
+			value = name[p+1:]
+			name = name[:p]
+		} else if name != "keep" {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("flag %s requires a value", arg)
+			}
+			value = args[0]
+			args = args[1:]
+		}
+		switch name {
+		case "name":
+			cfg.Name = value
+		case "env":
+			p := strings.IndexByte(value, '=')
+			if p <= 0 {
+				return nil, fmt.Errorf("invalid environment variable %q, must be K=V", value)
+			}
+			if cfg.Env == nil {
+				cfg.Env = make(map[string]string)
+			}
+			cfg.Env[value[:p]] = value[p+1:]
+		case "dir":
+			cfg.Dir = value
+		case "user":
+			cfg.User = value
+		case "keep":
+			cfg.keep = true
+		default:
+			return nil, fmt.Errorf("unknown run flag %s", arg)
+		}
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("run requires a command")
+	}
+	argv := Argv(args)
+	cfg.Args = &argv
+	cfg.Command = strings.Join(args, " ")
+	if cfg.Name == "" {
+		cfg.Name = filepath.Base(args[0])
+	}
+	cfg.Log = new(Logger)
+	cfg.Log.Parse("")
+	cfg.Log.Name = cfg.Name
+	return cfg, nil
+}
+
+// removeTransient removes the given service if it's transient,
+// stopped and it wasn't requested to be kept after stopping.
+func (g *Governator) removeTransient(s *Service) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removeTransientLocked(s)
+}
+
+func (g *Governator) removeTransientLocked(s *Service) {
+	if !s.Config.transient || s.Config.keep || s.State != StateStopped {
+		return
+	}
+	for ii, v := range g.services {
+		if v == s {
+			g.services = append(g.services[:ii], g.services[ii+1:]...)
+			break
+		}
+	}
+}
+
+// removeTransients removes all the stopped transient services
+// which weren't requested to be kept.
+func (g *Governator) removeTransients() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for ii := len(g.services) - 1; ii >= 0; ii-- {
+		g.removeTransientLocked(g.services[ii])
+	}
+}
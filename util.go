@@ -1,6 +1,15 @@
 package main
 
-import "sort"
+import (
+	"errors"
+	"os/exec"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var errTimedOut = errors.New("timed out")
 
 type servicesByPriority []*Service
 
@@ -9,6 +18,18 @@ func (s servicesByPriority) Less(i, j int) bool { return s[i].Config.Priority <
 func (s servicesByPriority) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
 func (s servicesByPriority) Sort()              { sort.Stable(s) }
 
+// tiers groups the sorted services by priority.
+func (s servicesByPriority) tiers() [][]*Service {
+	var tiers [][]*Service
+	for ii, v := range s {
+		if ii == 0 || v.Config.Priority != s[ii-1].Config.Priority {
+			tiers = append(tiers, nil)
+		}
+		tiers[len(tiers)-1] = append(tiers[len(tiers)-1], v)
+	}
+	return tiers
+}
+
 type quit struct {
 	stop    chan bool
 	stopped chan bool
@@ -42,3 +63,53 @@ func (q *quit) waitForStop() {
 func (q *quit) waitForStopped() {
 	<-q.stopped
 }
+
+// parseOptions consumes the leading key=value arguments from
+// args, returning them as a map and the remaining arguments.
+// Keys are converted to lowercase.
+func parseOptions(args []string) (map[string]string, []string) {
+	opts := make(map[string]string)
+	for ii, v := range args {
+		p := strings.IndexByte(v, '=')
+		if p <= 0 || !isOptionKey(v[:p]) {
+			return opts, args[ii:]
+		}
+		opts[strings.ToLower(v[:p])] = v[p+1:]
+	}
+	return opts, nil
+}
+
+func isOptionKey(s string) bool {
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z') && !(c >= 'A' && c <= 'Z') && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// runWithTimeout runs cmd in its own process group and waits for it
+// to finish. If it takes longer than timeout, the whole group is
+// killed, so its children don't outlive it, and errTimedOut is
+// returned.
+func runWithTimeout(cmd *exec.Cmd, timeout time.Duration) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return errTimedOut
+	}
+}
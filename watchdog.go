@@ -7,6 +7,9 @@ import (
 	"net/url"
 	"os/exec"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/fiam/stringutil"
@@ -15,19 +18,111 @@ import (
 const (
 	defaultWatchdogInterval = 300
 	defaultTimeout          = 60
+	// number of check results kept by each watchdog
+	watchdogHistorySize = 10
 )
 
+// watchdogResult is the result of a single watchdog check
+type watchdogResult struct {
+	Time     time.Time
+	Duration time.Duration
+	Err      error
+	// Manual is true for the checks requested with the check
+	// command, which aren't counted in the statistics
+	Manual bool
+}
+
+func (r *watchdogResult) String() string {
+	d := r.Duration.Round(time.Microsecond)
+	var manual string
+	if r.Manual {
+		manual = " (manual)"
+	}
+	if r.Err != nil {
+		return fmt.Sprintf("%s FAILED in %s%s - %s", formatTime(r.Time), d, manual, r.Err)
+	}
+	return fmt.Sprintf("%s OK in %s%s", formatTime(r.Time), d, manual)
+}
+
 type dog interface {
 	check() error
 }
 
+// serviceDog is implemented by the dogs which need
+// to inspect the service they're watching.
+type serviceDog interface {
+	dog
+	setService(s *Service)
+}
+
+// runDog runs a command, which must exit with 0 before the timeout.
+// The command runs with the environment, credentials and working
+// directory of the service and its output goes to the service logger.
+// Its syntax is:
+//
+//	run [timeout=N] <cmd> [args...]
 type runDog struct {
-	argv []string
+	argv    []string
+	timeout int
+	service *Service
+}
+
+func parseRunDog(args []string) (*runDog, error) {
+	opts, rem := parseOptions(args[1:])
+	if len(rem) == 0 {
+		return nil, fmt.Errorf("run watchdog requires at least one argument")
+	}
+	d := &runDog{argv: rem, timeout: defaultTimeout}
+	for k, v := range opts {
+		switch k {
+		case "timeout":
+			t, err := strconv.Atoi(v)
+			if err != nil || t <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q, must be a positive integer", v)
+			}
+			d.timeout = t
+		default:
+			return nil, fmt.Errorf("unknown run watchdog option %q", k)
+		}
+	}
+	return d, nil
+}
+
+func (d *runDog) setService(s *Service) {
+	d.service = s
 }
 
 func (d *runDog) check() error {
 	cmd := exec.Command(d.argv[0], d.argv[1:]...)
-	return cmd.Run()
+	var out *Out
+	if s := d.service; s != nil {
+		s.mu.Lock()
+		cfg := s.Config
+		s.mu.Unlock()
+		// Build the attributes from the configuration rather than
+		// from the running command, so they're also applied when
+		// the service is not running.
+		scmd, err := cfg.Cmd()
+		if err != nil {
+			return fmt.Errorf("can't prepare the service environment: %s", err)
+		}
+		cmd.Env = scmd.Env
+		cmd.Dir = scmd.Dir
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: scmd.SysProcAttr.Credential}
+		if cfg.Log != nil {
+			out = &Out{Logger: cfg.Log, prefix: "watchdog"}
+			cmd.Stdout = out
+			cmd.Stderr = out
+		}
+	}
+	err := runWithTimeout(cmd, time.Duration(d.timeout)*time.Second)
+	if out != nil {
+		out.flush()
+	}
+	if err == errTimedOut {
+		return fmt.Errorf("run watchdog timed out after %ds", d.timeout)
+	}
+	return err
 }
 
 func (d *runDog) String() string {
@@ -121,35 +216,107 @@ func (d *getDog) String() string {
 	return fmt.Sprintf("GET: %s", d.url)
 }
 
+// Watchdog periodically checks a service and restarts it after
+// FailureThreshold consecutive failed checks. Once a check has
+// failed, SuccessThreshold consecutive successful checks are required
+// to reset the failure count. No checks are performed during the
+// first InitialDelay seconds after the service is (re)started.
 type Watchdog struct {
-	service *Service
-	dog     dog
-	stop    chan bool
-	stopped chan bool
+	// Seconds between checks, 0 means the service's WatchdogInterval
+	Interval         int
+	FailureThreshold int
+	SuccessThreshold int
+	InitialDelay     int
+	input            string
+	service          *Service
+	dog              dog
+	stop             chan bool
+	stopped          chan bool
+	mu               sync.Mutex
+	checks           uint64
+	failures         uint64
+	lastDuration     time.Duration
+	failing          int
+	passing          int
+	healthy          bool
+	interval         int
+	checkAfter       time.Time
+	history          []watchdogResult
+}
+
+// delayChecks skips the checks for the next InitialDelay seconds. It's
+// called every time the service process is (re)started, to give it
+// time to initialize.
+func (w *Watchdog) delayChecks() {
+	w.mu.Lock()
+	w.checkAfter = time.Now().Add(time.Second * time.Duration(w.InitialDelay))
+	w.mu.Unlock()
+}
+
+func (w *Watchdog) checksDelayed(now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return now.Before(w.checkAfter)
 }
 
 func (w *Watchdog) Start(s *Service, interval int) error {
+	if w.Interval > 0 {
+		interval = w.Interval
+	} else if kd, ok := w.dog.(*keepaliveDog); ok {
+		interval = kd.interval()
+	}
 	w.service = s
+	if sd, ok := w.dog.(serviceDog); ok {
+		sd.setService(s)
+	}
+	// Start might be called again after a stop action
+	w.stopLoop()
 	w.stop = make(chan bool, 1)
 	w.stopped = make(chan bool, 1)
+	w.mu.Lock()
+	w.failing = 0
+	w.passing = 0
+	w.healthy = true
+	w.interval = interval
+	w.mu.Unlock()
+	w.delayChecks()
+	s.mu.Lock()
+	onFailure := s.Config.OnFailure
+	s.mu.Unlock()
 	ticker := time.NewTicker(time.Second * time.Duration(interval))
+	stop, stopped := w.stop, w.stopped
 	go func() {
+		defer ticker.Stop()
 		for {
-		stopWatchdog:
 			select {
-			case <-w.stop:
-				ticker.Stop()
-				w.stopped <- true
-				break stopWatchdog
-			case <-ticker.C:
+			case <-stop:
+				stopped <- true
+				return
+			case now := <-ticker.C:
+				if w.checksDelayed(now) {
+					break
+				}
 				s.infof("running watchdog %s", w.dog)
-				if err := w.Check(); err != nil {
+				err := w.run()
+				if err == nil {
+					s.infof("watchdog finished successfully")
+				} else {
 					s.errorf("watchdog returned an error: %s", err)
-					if err := s.stopService(); err == nil {
-						s.startService()
+				}
+				failed, recovered := w.update(err)
+				if recovered {
+					s.infof("watchdog %s recovered", w.dog)
+					s.setUnhealthy(nil)
+				}
+				if failed {
+					s.emit(EventWatchdogFailed, err)
+					res := onFailure.execute(s, w, err)
+					if res.stopped {
+						// Starting the service again starts
+						// a new loop
+						stopped <- true
+						return
 					}
-				} else {
-					s.infof("watchdog finished successfully")
 				}
 			}
 		}
@@ -157,11 +324,112 @@ func (w *Watchdog) Start(s *Service, interval int) error {
 	return nil
 }
 
+// update records the result of a check. It returns whether the
+// failure threshold has been reached and whether the watchdog
+// has recovered after failing.
+func (w *Watchdog) update(err error) (failed bool, recovered bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err != nil {
+		w.passing = 0
+		w.failing++
+		if w.failing >= w.failureThreshold() {
+			w.failing = 0
+			w.healthy = false
+			return true, false
+		}
+		return false, false
+	}
+	// Failures must be consecutive to trigger the failure actions
+	w.failing = 0
+	w.passing++
+	if w.passing >= w.successThreshold() {
+		if !w.healthy {
+			w.healthy = true
+			return false, true
+		}
+	}
+	return false, false
+}
+
+func (w *Watchdog) failureThreshold() int {
+	if w.FailureThreshold > 0 {
+		return w.FailureThreshold
+	}
+	return 1
+}
+
+func (w *Watchdog) successThreshold() int {
+	if w.SuccessThreshold > 0 {
+		return w.SuccessThreshold
+	}
+	return 1
+}
+
+func (w *Watchdog) String() string {
+	return fmt.Sprint(w.dog)
+}
+
 func (w *Watchdog) Check() error {
 	return w.dog.check()
 }
 
-func (w *Watchdog) Stop() {
+// run performs a check and records its statistics
+func (w *Watchdog) run() error {
+	return w.runCheck(false).Err
+}
+
+// runCheck performs a check and records it in the history. Manual
+// checks are marked as such and don't count in the statistics.
+func (w *Watchdog) runCheck(manual bool) watchdogResult {
+	start := time.Now()
+	err := w.Check()
+	elapsed := time.Since(start)
+	w.mu.Lock()
+	if !manual {
+		w.checks++
+		if err != nil {
+			w.failures++
+		}
+		w.lastDuration = elapsed
+	}
+	if len(w.history) == watchdogHistorySize {
+		copy(w.history, w.history[1:])
+		w.history = w.history[:len(w.history)-1]
+	}
+	res := watchdogResult{Time: start, Duration: elapsed, Err: err, Manual: manual}
+	w.history = append(w.history, res)
+	w.mu.Unlock()
+	return res
+}
+
+// History returns the results of the last checks, oldest first.
+func (w *Watchdog) History() []watchdogResult {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]watchdogResult(nil), w.history...)
+}
+
+// runInterval returns the seconds between checks while the
+// watchdog is running, or 0 if it's not running.
+func (w *Watchdog) runInterval() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.interval
+}
+
+// Stats returns the number of checks and failures and
+// the duration of the last check.
+func (w *Watchdog) Stats() (checks uint64, failures uint64, last time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.checks, w.failures, w.lastDuration
+}
+
+func (w *Watchdog) stopLoop() {
+	w.mu.Lock()
+	w.interval = 0
+	w.mu.Unlock()
 	if w.stop != nil {
 		w.stop <- true
 		<-w.stopped
@@ -170,21 +438,72 @@ func (w *Watchdog) Stop() {
 	}
 }
 
+func (w *Watchdog) Stop() {
+	w.stopLoop()
+	if kd, ok := w.dog.(*keepaliveDog); ok {
+		kd.close()
+	}
+}
+
+// UnmarshalText parses the watchdog using Parse.
+func (w *Watchdog) UnmarshalText(text []byte) error {
+	return w.Parse(string(text))
+}
+
+// splitWatchdogFields splits a watchdog configuration into its fields.
+// SplitFields consumes the backslashes, but the tcp and unix watchdogs
+// interpret the escapes in their payloads and regexps by themselves,
+// so the backslashes are preserved for them.
+func splitWatchdogFields(input string) ([]string, error) {
+	args, err := stringutil.SplitFields(input, " ")
+	if err != nil {
+		return nil, err
+	}
+	if _, rem := parseOptions(args); len(rem) > 0 && (rem[0] == "tcp" || rem[0] == "unix") {
+		return stringutil.SplitFields(strings.Replace(input, `\`, `\\`, -1), " ")
+	}
+	return args, nil
+}
+
+// Parse parses a watchdog configuration, which uses the syntax:
+//
+//	[interval=N] [failures=N] [successes=N] [delay=N] <type> [args...]
 func (w *Watchdog) Parse(input string) error {
+	w.input = input
 	if input == "" {
 		return nil
 	}
-	args, err := stringutil.SplitFields(input, " ")
+	args, err := splitWatchdogFields(input)
 	if err != nil {
 		return err
 	}
+	opts, args := parseOptions(args)
+	for k, v := range opts {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid watchdog %s %q, must be a non-negative integer", k, v)
+		}
+		switch k {
+		case "interval":
+			w.Interval = n
+		case "failures":
+			w.FailureThreshold = n
+		case "successes":
+			w.SuccessThreshold = n
+		case "delay":
+			w.InitialDelay = n
+		default:
+			return fmt.Errorf("unknown watchdog option %q", k)
+		}
+	}
 	if len(args) > 0 {
 		switch args[0] {
 		case "run":
-			if len(args) == 1 {
-				return fmt.Errorf("run watchdog requires at least one argument")
+			d, err := parseRunDog(args)
+			if err != nil {
+				return err
 			}
-			w.dog = &runDog{args[1:]}
+			w.dog = d
 		case "connect":
 			if len(args) != 2 && len(args) != 3 {
 				return fmt.Errorf("connect watchdog requires one or two arguments, %d given", len(args))
@@ -220,10 +539,46 @@ func (w *Watchdog) Parse(input string) error {
 				return err
 			}
 			w.dog = &getDog{args[1], timeout}
+		case "http":
+			d, err := parseHTTPDog(args)
+			if err != nil {
+				return err
+			}
+			w.dog = d
+		case "tcp", "unix":
+			d, err := parseSocketDog(args)
+			if err != nil {
+				return err
+			}
+			w.dog = d
+		case "grpc":
+			d, err := parseGRPCDog(args)
+			if err != nil {
+				return err
+			}
+			w.dog = d
+		case "file":
+			d, err := parseFileDog(args)
+			if err != nil {
+				return err
+			}
+			w.dog = d
+		case "proc":
+			d, err := parseProcDog(args)
+			if err != nil {
+				return err
+			}
+			w.dog = d
+		case "keepalive":
+			d, err := parseKeepaliveDog(args)
+			if err != nil {
+				return err
+			}
+			w.dog = d
 		}
 	}
 	if w.dog == nil {
-		return fmt.Errorf("invalid watchdog %q - available watchdogs are run, connect and get", input)
+		return fmt.Errorf("invalid watchdog %q - available watchdogs are run, connect, get, http, tcp, unix, grpc, file, proc and keepalive", input)
 	}
 	return nil
 }
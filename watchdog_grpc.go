@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// grpcDog calls grpc.health.v1.Health/Check and fails unless the
+// response status is SERVING. Its syntax is:
+//
+//	grpc <host:port> [service=name] [timeout=N] [tls=bool]
+//	    [ca=file] [insecure=bool] [cert=file] [key=file]
+//
+// TLS is used when tls=true or when any of the TLS options is given.
+type grpcDog struct {
+	addr    string
+	service string
+	timeout int
+	creds   credentials.TransportCredentials
+}
+
+func parseGRPCDog(args []string) (*grpcDog, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("grpc watchdog requires an address")
+	}
+	d := &grpcDog{addr: args[1], timeout: defaultTimeout}
+	opts, rem := parseOptions(args[2:])
+	if len(rem) > 0 {
+		return nil, fmt.Errorf("invalid grpc watchdog option %q", rem[0])
+	}
+	var tlsOpts tlsOptions
+	useTLS := false
+	for k, v := range opts {
+		if ok, err := tlsOpts.parseOption(k, v); ok {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch k {
+		case "service":
+			d.service = v
+		case "timeout":
+			t, err := strconv.Atoi(v)
+			if err != nil || t <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q, must be a positive integer", v)
+			}
+			d.timeout = t
+		case "tls":
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tls value %q, must be a boolean", v)
+			}
+			useTLS = b
+		default:
+			return nil, fmt.Errorf("unknown grpc watchdog option %q", k)
+		}
+	}
+	tlsConfig, err := tlsOpts.config()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case tlsConfig != nil:
+		d.creds = credentials.NewTLS(tlsConfig)
+	case useTLS:
+		d.creds = credentials.NewTLS(nil)
+	default:
+		d.creds = insecure.NewCredentials()
+	}
+	return d, nil
+}
+
+func (d *grpcDog) check() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(d.timeout)*time.Second)
+	defer cancel()
+	conn, err := grpc.NewClient(d.addr, grpc.WithTransportCredentials(d.creds),
+		grpc.WithUserAgent(fmt.Sprintf("%s watchdog", AppName)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: d.service}, grpc.WaitForReady(true))
+	if err != nil {
+		return err
+	}
+	if st := resp.GetStatus(); st != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("health status is %s", strings.ToLower(st.String()))
+	}
+	return nil
+}
+
+func (d *grpcDog) String() string {
+	if d.service != "" {
+		return fmt.Sprintf("grpc: %s (%s)", d.addr, d.service)
+	}
+	return fmt.Sprintf("grpc: %s", d.addr)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestGRPCWatchdog(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	hs := health.NewServer()
+	hs.SetServingStatus("api", healthpb.HealthCheckResponse_SERVING)
+	hs.SetServingStatus("db", healthpb.HealthCheckResponse_NOT_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(l)
+	defer srv.Stop()
+	addr := l.Addr().String()
+	tests := []watchdogTest{
+		{"grpc " + addr, "", ""},
+		{"grpc " + addr + " service=api", "", ""},
+		{"grpc " + addr + " service=db", "", "health status is not_serving"},
+		{"grpc " + addr + " service=missing timeout=1", "", "contains:NotFound"},
+		{"grpc " + addr + " tls=true timeout=1", "", "contains:DeadlineExceeded"},
+		{"grpc " + addr + " foo=bar", "unknown grpc watchdog option \"foo\"", ""},
+		{"grpc " + addr + " key=client.key", "client certificates require both cert and key", ""},
+	}
+	for _, v := range tests {
+		t.Logf("testing wd config %q", v.config)
+		w := new(Watchdog)
+		err := w.Parse(v.config)
+		if !checkExpectedErr(t, err, v.perr) || err != nil {
+			continue
+		}
+		checkExpectedErr(t, w.Check(), v.cerr)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maximum number of bytes read from the response body
+	httpDogMaxBody = 1024 * 1024
+)
+
+// statusRange is an inclusive range of HTTP status codes
+type statusRange struct {
+	min int
+	max int
+}
+
+func parseStatusRanges(s string) ([]statusRange, error) {
+	var ranges []statusRange
+	for _, v := range strings.Split(s, ",") {
+		var r statusRange
+		var err error
+		if p := strings.IndexByte(v, '-'); p >= 0 {
+			if r.min, err = strconv.Atoi(v[:p]); err == nil {
+				r.max, err = strconv.Atoi(v[p+1:])
+			}
+		} else {
+			r.min, err = strconv.Atoi(v)
+			r.max = r.min
+		}
+		if err != nil || r.min < 100 || r.max > 599 || r.min > r.max {
+			return nil, fmt.Errorf("invalid status %q, must be a code (e.g. 204) or a range (e.g. 200-299)", v)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// httpDog performs an HTTP request and checks the response. Its syntax is:
+//
+//	http <url> [method=M] [header=Name:Value]... [status=200,204,300-399]
+//	    [body=regexp] [json=path[=value]] [redirects=N] [timeout=N]
+//	    [ca=file] [insecure=bool] [cert=file] [key=file]
+//
+// By default, any 2xx status is accepted. json checks that the value at
+// the given dot separated path (e.g. checks.db.status or items.0.name)
+// exists and is not null nor false or, if a value is provided, that its
+// string representation matches it.
+type httpDog struct {
+	url       string
+	method    string
+	header    http.Header
+	status    []statusRange
+	body      *regexp.Regexp
+	jsonPath  []string
+	jsonValue *string
+	client    *http.Client
+}
+
+func parseHTTPDog(args []string) (*httpDog, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("http watchdog requires an URL")
+	}
+	u, err := url.Parse(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid http URL %q: %s", args[1], err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid http URL scheme %q - must be http or https", u.Scheme)
+	}
+	d := &httpDog{
+		url:    args[1],
+		method: "GET",
+		header: make(http.Header),
+		status: []statusRange{{200, 299}},
+	}
+	var tlsOpts tlsOptions
+	timeout := defaultTimeout
+	redirects := -1
+	// Options are parsed by hand, since header might be repeated
+	for _, v := range args[2:] {
+		p := strings.IndexByte(v, '=')
+		if p <= 0 {
+			return nil, fmt.Errorf("invalid http watchdog option %q, must be key=value", v)
+		}
+		key, value := strings.ToLower(v[:p]), v[p+1:]
+		if ok, err := tlsOpts.parseOption(key, value); ok {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		switch key {
+		case "method":
+			d.method = strings.ToUpper(value)
+		case "header":
+			sep := strings.IndexByte(value, ':')
+			if sep <= 0 {
+				return nil, fmt.Errorf("invalid header %q, must be Name:Value", value)
+			}
+			d.header.Add(strings.TrimSpace(value[:sep]), strings.TrimSpace(value[sep+1:]))
+		case "status":
+			if d.status, err = parseStatusRanges(value); err != nil {
+				return nil, err
+			}
+		case "body":
+			if d.body, err = regexp.Compile(value); err != nil {
+				return nil, fmt.Errorf("invalid body regexp %q: %s", value, err)
+			}
+		case "json":
+			path := value
+			if sep := strings.IndexByte(value, '='); sep >= 0 {
+				path = value[:sep]
+				expected := value[sep+1:]
+				d.jsonValue = &expected
+			}
+			if path == "" {
+				return nil, fmt.Errorf("invalid json assertion %q, path can't be empty", value)
+			}
+			d.jsonPath = strings.Split(path, ".")
+		case "redirects":
+			if redirects, err = strconv.Atoi(value); err != nil || redirects < 0 {
+				return nil, fmt.Errorf("invalid redirects %q, must be a non-negative integer", value)
+			}
+		case "timeout":
+			if timeout, err = strconv.Atoi(value); err != nil || timeout <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q, must be a positive integer", value)
+			}
+		default:
+			return nil, fmt.Errorf("unknown http watchdog option %q", key)
+		}
+	}
+	tlsConfig, err := tlsOpts.config()
+	if err != nil {
+		return nil, err
+	}
+	d.client = &http.Client{
+		Timeout: time.Duration(timeout) * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	if redirects >= 0 {
+		d.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			if len(via) > redirects {
+				// Check the last response instead
+				return http.ErrUseLastResponse
+			}
+			return nil
+		}
+	}
+	return d, nil
+}
+
+func (d *httpDog) check() error {
+	req, err := http.NewRequest(d.method, d.url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range d.header {
+		req.Header[k] = v
+	}
+	if host := d.header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", fmt.Sprintf("%s watchdog", AppName))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if !d.statusOk(resp.StatusCode) {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	if d.body == nil && d.jsonPath == nil {
+		return nil
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, httpDogMaxBody))
+	if err != nil {
+		return err
+	}
+	if d.body != nil && !d.body.Match(data) {
+		return fmt.Errorf("body does not match %q", d.body)
+	}
+	if d.jsonPath != nil {
+		return d.checkJSON(data)
+	}
+	return nil
+}
+
+func (d *httpDog) statusOk(code int) bool {
+	for _, v := range d.status {
+		if code >= v.min && code <= v.max {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *httpDog) checkJSON(data []byte) error {
+	var val interface{}
+	if err := json.Unmarshal(data, &val); err != nil {
+		return fmt.Errorf("invalid JSON body: %s", err)
+	}
+	path := strings.Join(d.jsonPath, ".")
+	for _, v := range d.jsonPath {
+		switch x := val.(type) {
+		case map[string]interface{}:
+			val = x[v]
+		case []interface{}:
+			idx, err := strconv.Atoi(v)
+			if err != nil || idx < 0 || idx >= len(x) {
+				return fmt.Errorf("JSON path %s not found", path)
+			}
+			val = x[idx]
+		default:
+			return fmt.Errorf("JSON path %s not found", path)
+		}
+	}
+	if d.jsonValue == nil {
+		if val == nil || val == false {
+			return fmt.Errorf("JSON path %s is %v", path, val)
+		}
+		return nil
+	}
+	var s string
+	switch x := val.(type) {
+	case nil:
+		s = "null"
+	case string:
+		s = x
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(x)
+		s = string(b)
+	default:
+		s = fmt.Sprint(x)
+	}
+	if s != *d.jsonValue {
+		return fmt.Errorf("JSON path %s is %q, expecting %q", path, s, *d.jsonValue)
+	}
+	return nil
+}
+
+func (d *httpDog) String() string {
+	return fmt.Sprintf("%s: %s", d.method, d.url)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func testHTTPHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/health":
+		w.WriteHeader(http.StatusNoContent)
+	case "/auth":
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	case "/method":
+		if r.Method != "HEAD" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	case "/status":
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status": "degraded", "checks": [{"name": "db", "ok": true}, {"name": "cache", "ok": false}]}`))
+	case "/redirect":
+		http.Redirect(w, r, "/health", http.StatusFound)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestHTTPWatchdog(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(testHTTPHandler))
+	defer srv.Close()
+	tests := []watchdogTest{
+		{"http " + srv.URL + "/health", "", ""},
+		{"http " + srv.URL + "/health status=200", "", "unexpected status code 204"},
+		{"http " + srv.URL + "/missing status=200,404", "", ""},
+		{"http " + srv.URL + "/missing status=500-599", "", "unexpected status code 404"},
+		{"http " + srv.URL + "/auth", "", "unexpected status code 401"},
+		{"http " + srv.URL + "/auth \"header=Authorization: Bearer secret\"", "", ""},
+		{"http " + srv.URL + "/method", "", "unexpected status code 405"},
+		{"http " + srv.URL + "/method method=head", "", ""},
+		{"http " + srv.URL + "/status 'body=\"status\": \"ok\"'", "", "contains:body does not match"},
+		{"http " + srv.URL + "/status body=degraded|ok", "", ""},
+		{"http " + srv.URL + "/status json=status=ok", "", "JSON path status is \"degraded\", expecting \"ok\""},
+		{"http " + srv.URL + "/status json=status=degraded", "", ""},
+		{"http " + srv.URL + "/status json=checks.0.ok", "", ""},
+		{"http " + srv.URL + "/status json=checks.1.ok", "", "JSON path checks.1.ok is false"},
+		{"http " + srv.URL + "/status json=checks.2.ok", "", "JSON path checks.2.ok not found"},
+		{"http " + srv.URL + "/redirect status=204", "", ""},
+		{"http " + srv.URL + "/redirect status=204 redirects=0", "", "unexpected status code 302"},
+		{"http " + srv.URL + "/redirect status=302 redirects=0", "", ""},
+		{"http ftp://example.com", "invalid http URL scheme \"ftp\" - must be http or https", ""},
+		{"http " + srv.URL + " status=99", "contains:invalid status", ""},
+		{"http " + srv.URL + " foo=bar", "unknown http watchdog option \"foo\"", ""},
+		{"http " + srv.URL + " cert=client.pem", "client certificates require both cert and key", ""},
+	}
+	for _, v := range tests {
+		t.Logf("testing wd config %q", v.config)
+		w := new(Watchdog)
+		err := w.Parse(v.config)
+		if !checkExpectedErr(t, err, v.perr) || err != nil {
+			continue
+		}
+		checkExpectedErr(t, w.Check(), v.cerr)
+	}
+}
+
+func TestHTTPWatchdogTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(testHTTPHandler))
+	defer srv.Close()
+	ca, err := ioutil.TempFile("", "governator-ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(ca.Name())
+	pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	ca.Close()
+	tests := []watchdogTest{
+		{"http " + srv.URL + "/health", "", "contains:certificate"},
+		{"http " + srv.URL + "/health insecure=true", "", ""},
+		{"http " + srv.URL + "/health ca=" + ca.Name(), "", ""},
+	}
+	for _, v := range tests {
+		t.Logf("testing wd config %q", v.config)
+		w := new(Watchdog)
+		err := w.Parse(v.config)
+		if !checkExpectedErr(t, err, v.perr) || err != nil {
+			continue
+		}
+		checkExpectedErr(t, w.Check(), v.cerr)
+	}
+}
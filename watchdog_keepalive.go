@@ -0,0 +1,189 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// envDog is implemented by the dogs which need to pass
+// environment variables to the service. env is called
+// every time the service is started.
+type envDog interface {
+	dog
+	env() ([]string, error)
+}
+
+// keepaliveDog inverts the checks: the service must send keepalives
+// and the dog fails if none arrive within the given number of seconds.
+// Its syntax is:
+//
+//	keepalive <seconds>
+//
+// Keepalives use the sd_notify(3) protocol: the service receives the
+// path to a unix datagram socket in NOTIFY_SOCKET and the timeout in
+// WATCHDOG_USEC, and it must send WATCHDOG=1 to the socket. Since the
+// pid is not known before starting the service, WATCHDOG_PID is not set.
+// Keepalives are only accepted from processes in the process group of
+// the service, since the socket is writable by any user.
+type keepaliveDog struct {
+	timeout time.Duration
+	mu      sync.Mutex
+	service *Service
+	dir     string
+	conn    *net.UnixConn
+	last    time.Time
+}
+
+func parseKeepaliveDog(args []string) (*keepaliveDog, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("keepalive watchdog requires a timeout, %d arguments given", len(args)-1)
+	}
+	secs, err := strconv.Atoi(args[1])
+	if err != nil || secs <= 0 {
+		return nil, fmt.Errorf("invalid keepalive timeout %q, must be a positive integer", args[1])
+	}
+	return &keepaliveDog{timeout: time.Duration(secs) * time.Second}, nil
+}
+
+// interval returns the default check interval in seconds. Checks
+// must be frequent, otherwise a missing keepalive would only be
+// detected long after the timeout.
+func (d *keepaliveDog) interval() int {
+	if i := int(d.timeout/time.Second) / 4; i > 0 {
+		return i
+	}
+	return 1
+}
+
+func (d *keepaliveDog) setService(s *Service) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.service = s
+}
+
+func (d *keepaliveDog) socketPath() string {
+	return filepath.Join(d.dir, "notify.sock")
+}
+
+func (d *keepaliveDog) env() ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn == nil {
+		if err := d.open(); err != nil {
+			return nil, err
+		}
+	}
+	// Give the service a full timeout after starting
+	d.last = time.Now()
+	return []string{
+		"NOTIFY_SOCKET=" + d.socketPath(),
+		fmt.Sprintf("WATCHDOG_USEC=%d", d.timeout/time.Microsecond),
+	}, nil
+}
+
+func (d *keepaliveDog) open() error {
+	dir, err := ioutil.TempDir("", AppName+"-notify")
+	if err != nil {
+		return err
+	}
+	// Services might run as any user
+	if err := os.Chmod(dir, 0755); err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	d.dir = dir
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: d.socketPath(), Net: "unixgram"})
+	if err != nil {
+		os.RemoveAll(dir)
+		return err
+	}
+	if err := enableCredentials(conn); err != nil {
+		conn.Close()
+		os.RemoveAll(dir)
+		return err
+	}
+	if err := os.Chmod(d.socketPath(), 0666); err != nil {
+		conn.Close()
+		os.RemoveAll(dir)
+		return err
+	}
+	d.conn = conn
+	go d.read(conn)
+	return nil
+}
+
+func (d *keepaliveDog) read(conn *net.UnixConn) {
+	buf := make([]byte, 4096)
+	oob := credentialsBuffer()
+	for {
+		n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			return
+		}
+		pid, ok := credentialsPid(oob[:oobn])
+		if !ok || !d.fromService(pid) {
+			continue
+		}
+		for _, v := range strings.Split(string(buf[:n]), "\n") {
+			if v == "WATCHDOG=1" {
+				d.mu.Lock()
+				d.last = time.Now()
+				d.mu.Unlock()
+			}
+		}
+	}
+}
+
+// fromService returns true iff the process with the given
+// pid belongs to the process group of the service.
+func (d *keepaliveDog) fromService(pid int) bool {
+	d.mu.Lock()
+	s := d.service
+	d.mu.Unlock()
+	if s == nil {
+		return false
+	}
+	spid := s.pid()
+	if spid <= 0 {
+		return false
+	}
+	if pid == spid {
+		return true
+	}
+	pgid, err := syscall.Getpgid(pid)
+	return err == nil && pgid == spid
+}
+
+func (d *keepaliveDog) close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn != nil {
+		d.conn.Close()
+		os.RemoveAll(d.dir)
+		d.conn = nil
+	}
+}
+
+func (d *keepaliveDog) check() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn == nil {
+		return fmt.Errorf("keepalive socket is not open, the service must be restarted")
+	}
+	if since := time.Since(d.last); since > d.timeout {
+		return fmt.Errorf("no keepalive received in %s, timeout is %s", since-since%time.Second, d.timeout)
+	}
+	return nil
+}
+
+func (d *keepaliveDog) String() string {
+	return fmt.Sprintf("keepalive: %s", d.timeout)
+}
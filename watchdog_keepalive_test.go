@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sendKeepalive(t *testing.T, path string) {
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("STATUS=running\nWATCHDOG=1\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeepaliveWatchdog(t *testing.T) {
+	w := new(Watchdog)
+	if err := w.Parse("keepalive 1"); err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	checkExpectedErr(t, w.Check(), "contains:not open")
+	env, err := w.dog.(envDog).env()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var path string
+	for _, v := range env {
+		if strings.HasPrefix(v, "NOTIFY_SOCKET=") {
+			path = strings.TrimPrefix(v, "NOTIFY_SOCKET=")
+		}
+	}
+	if path == "" || env[1] != "WATCHDOG_USEC=1000000" {
+		t.Fatalf("unexpected environment %q", env)
+	}
+	if err := w.Check(); err != nil {
+		t.Error(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	checkExpectedErr(t, w.Check(), "contains:no keepalive received")
+	// Keepalives from outside the service must be ignored
+	sendKeepalive(t, path)
+	time.Sleep(100 * time.Millisecond)
+	checkExpectedErr(t, w.Check(), "contains:no keepalive received")
+	if err := w.Parse("keepalive"); err == nil {
+		t.Error("expecting an error without a timeout")
+	}
+}
+
+func TestKeepaliveFromService(t *testing.T) {
+	g := prepareGovernatorTest(t)
+	defer afterGovernatorTest(t, g)
+	for _, v := range []struct {
+		name    string
+		command string
+		healthy bool
+	}{
+		{"keepalive-sender", "python " + abs(filepath.Join("_testdata", "keepalive.py")), true},
+		{"keepalive-silent", "sleep 50000", false},
+	} {
+		w := new(Watchdog)
+		if err := w.Parse("interval=60 keepalive 1"); err != nil {
+			t.Fatal(err)
+		}
+		cfg := &Config{
+			File:     "/non-existant",
+			Command:  v.command,
+			Name:     v.name,
+			Watchdog: w,
+		}
+		setLogger(t, cfg, "none")
+		name, err := g.AddService(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := g.Start(name); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(1500 * time.Millisecond)
+		if v.healthy {
+			if err := w.Check(); err != nil {
+				t.Errorf("%s: %s", name, err)
+			}
+		} else {
+			checkExpectedErr(t, w.Check(), "contains:no keepalive received")
+		}
+		if err := g.Stop(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fiam/parseutil"
+)
+
+// fileDog fails when the modification time of a file is older
+// than the given number of seconds, which lets services without
+// a network port signal they're alive by touching a file. Its
+// syntax is:
+//
+//	file <path> <seconds>
+type fileDog struct {
+	path   string
+	maxAge time.Duration
+}
+
+func parseFileDog(args []string) (*fileDog, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("file watchdog requires a path and a maximum age, %d arguments given", len(args)-1)
+	}
+	age, err := strconv.Atoi(args[2])
+	if err != nil || age <= 0 {
+		return nil, fmt.Errorf("invalid file maximum age %q, must be a positive integer", args[2])
+	}
+	return &fileDog{path: args[1], maxAge: time.Duration(age) * time.Second}, nil
+}
+
+func (d *fileDog) check() error {
+	info, err := os.Stat(d.path)
+	if err != nil {
+		return err
+	}
+	if age := time.Since(info.ModTime()); age > d.maxAge {
+		return fmt.Errorf("%s was last modified %s ago, maximum is %s", d.path, age-age%time.Second, d.maxAge)
+	}
+	return nil
+}
+
+func (d *fileDog) String() string {
+	return fmt.Sprintf("file: %s (%s)", d.path, d.maxAge)
+}
+
+const (
+	// default number of seconds a process must stay in
+	// uninterruptible sleep before the proc watchdog fails
+	defaultProcDState = 60
+)
+
+// procDog inspects the main process of the service and fails if it's
+// a zombie, it's stuck in uninterruptible sleep (D state) or if it
+// exceeds any of the given limits. Its syntax is:
+//
+//	proc [rss=size] [threads=N] [fds=N] [dstate=N]
+//
+// The rss limit accepts units (e.g. 512M or 2G). Since processes
+// enter D state briefly while doing I/O, the process must be found
+// in D state by consecutive checks spanning at least dstate seconds
+// (60 by default) to be considered stuck.
+type procDog struct {
+	service *Service
+	rss     uint64
+	threads int
+	fds     int
+	dstate  time.Duration
+	mu      sync.Mutex
+	// pid and time of the first of the consecutive
+	// samples which found the process in D state
+	dPid   int
+	dSince time.Time
+}
+
+func parseProcDog(args []string) (*procDog, error) {
+	opts, rem := parseOptions(args[1:])
+	if len(rem) > 0 {
+		return nil, fmt.Errorf("invalid proc watchdog option %q", rem[0])
+	}
+	d := &procDog{dstate: defaultProcDState * time.Second}
+	for k, v := range opts {
+		switch k {
+		case "rss":
+			size, err := parseutil.Size(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rss %q: %s", v, err)
+			}
+			d.rss = size
+		case "threads", "fds", "dstate":
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid %s %q, must be a positive integer", k, v)
+			}
+			switch k {
+			case "threads":
+				d.threads = n
+			case "fds":
+				d.fds = n
+			default:
+				d.dstate = time.Duration(n) * time.Second
+			}
+		default:
+			return nil, fmt.Errorf("unknown proc watchdog option %q", k)
+		}
+	}
+	return d, nil
+}
+
+func (d *procDog) setService(s *Service) {
+	d.service = s
+}
+
+func (d *procDog) check() error {
+	if d.service == nil {
+		return fmt.Errorf("proc watchdog is not attached to a service")
+	}
+	pid := d.service.pid()
+	if pid <= 0 {
+		return fmt.Errorf("%s is not running", d.service.Name())
+	}
+	st, err := readProcStat(pid)
+	if err != nil {
+		return err
+	}
+	if st.State == 'Z' {
+		return fmt.Errorf("process %d is a zombie", pid)
+	}
+	if err := d.checkDState(pid, st.State, time.Now()); err != nil {
+		return err
+	}
+	if d.rss > 0 && st.RSSBytes() > d.rss {
+		return fmt.Errorf("process %d RSS is %d bytes, maximum is %d", pid, st.RSSBytes(), d.rss)
+	}
+	if d.threads > 0 && st.NumThreads > d.threads {
+		return fmt.Errorf("process %d has %d threads, maximum is %d", pid, st.NumThreads, d.threads)
+	}
+	if d.fds > 0 {
+		fds, err := ioutil.ReadDir(fmt.Sprintf("/proc/%d/fd", pid))
+		if err != nil {
+			return err
+		}
+		if len(fds) > d.fds {
+			return fmt.Errorf("process %d has %d open files, maximum is %d", pid, len(fds), d.fds)
+		}
+	}
+	return nil
+}
+
+// checkDState records a sample of the process state and returns
+// an error if the process has been in D state since a previous
+// sample taken at least d.dstate ago.
+func (d *procDog) checkDState(pid int, state byte, now time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if state != 'D' {
+		d.dPid = 0
+		d.dSince = time.Time{}
+		return nil
+	}
+	if d.dPid != pid {
+		d.dPid = pid
+		d.dSince = now
+	}
+	if elapsed := now.Sub(d.dSince); elapsed >= d.dstate {
+		return fmt.Errorf("process %d has been in uninterruptible sleep for %s", pid, elapsed-elapsed%time.Second)
+	}
+	return nil
+}
+
+func (d *procDog) String() string {
+	return "proc"
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestFileWatchdog(t *testing.T) {
+	f, err := ioutil.TempFile("", "governator-heartbeat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	w := new(Watchdog)
+	if err := w.Parse("file " + f.Name() + " 60"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Check(); err != nil {
+		t.Error(err)
+	}
+	old := time.Now().Add(-2 * time.Minute)
+	if err := os.Chtimes(f.Name(), old, old); err != nil {
+		t.Fatal(err)
+	}
+	checkExpectedErr(t, w.Check(), "contains:was last modified")
+	if err := w.Parse("file " + f.Name()); err == nil {
+		t.Error("expecting an error without a maximum age")
+	}
+}
+
+func TestProcWatchdog(t *testing.T) {
+	g := prepareGovernatorTest(t)
+	defer afterGovernatorTest(t, g)
+	cfg := &Config{
+		File:    "/non-existant",
+		Command: "sleep 50000",
+		Name:    "sleep-proc",
+	}
+	name, err := g.AddService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := g.serviceByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []watchdogTest{
+		{"proc", "", ""},
+		{"proc rss=1G threads=100 fds=100", "", ""},
+		{"proc threads=1 fds=1", "", "contains:open files"},
+		{"proc rss=1K", "", "contains:RSS"},
+		{"proc dstate=10", "", ""},
+		{"proc dstate=0", "invalid dstate \"0\", must be a positive integer", ""},
+		{"proc foo=1", "unknown proc watchdog option \"foo\"", ""},
+	}
+	w := new(Watchdog)
+	if err := w.Parse("proc"); err != nil {
+		t.Fatal(err)
+	}
+	w.dog.(serviceDog).setService(s)
+	checkExpectedErr(t, w.Check(), "contains:not running")
+	if err := g.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop(name)
+	for _, v := range tests {
+		t.Logf("testing wd config %q", v.config)
+		w := new(Watchdog)
+		err := w.Parse(v.config)
+		if !checkExpectedErr(t, err, v.perr) || err != nil {
+			continue
+		}
+		w.dog.(serviceDog).setService(s)
+		checkExpectedErr(t, w.Check(), v.cerr)
+	}
+}
+
+func TestProcWatchdogDState(t *testing.T) {
+	d, err := parseProcDog([]string{"proc", "dstate=10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	steps := []struct {
+		pid    int
+		state  byte
+		offset time.Duration
+		fail   bool
+	}{
+		// a single sample in D state doesn't fail
+		{1, 'D', 0, false},
+		{1, 'S', 5 * time.Second, false},
+		{1, 'D', 6 * time.Second, false},
+		{1, 'D', 15 * time.Second, false},
+		{1, 'D', 16 * time.Second, true},
+		{1, 'D', 20 * time.Second, true},
+		// a new process starts over
+		{2, 'D', 21 * time.Second, false},
+		{2, 'R', 40 * time.Second, false},
+		{2, 'D', 41 * time.Second, false},
+	}
+	for ii, v := range steps {
+		err := d.checkDState(v.pid, v.state, now.Add(v.offset))
+		if (err != nil) != v.fail {
+			t.Errorf("step %d: expecting failure = %v, got %v", ii, v.fail, err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maximum number of bytes read while waiting for a response
+	socketDogMaxResponse = 64 * 1024
+)
+
+// socketDog connects to a TCP or unix socket, optionally sends
+// a payload and waits for a response matching a regexp. Its
+// syntax is:
+//
+//	tcp <host:port> [send=payload] [expect=regexp] [timeout=N]
+//	unix <path> [send=payload] [expect=regexp] [timeout=N]
+//
+// The payload accepts the same escapes as Go strings, so
+// send=PING\r\n sends PING followed by CRLF. Backslashes are passed
+// as is to the payload and the regexp, so payloads or regexps with
+// spaces must be quoted rather than escaped (e.g. send="GET / \r\n").
+type socketDog struct {
+	network string
+	addr    string
+	send    []byte
+	expect  *regexp.Regexp
+	timeout int
+}
+
+// unescapePayload interprets the backslash escapes in s.
+func unescapePayload(s string) (string, error) {
+	return strconv.Unquote("\"" + strings.Replace(s, "\"", "\\\"", -1) + "\"")
+}
+
+func parseSocketDog(args []string) (*socketDog, error) {
+	network := args[0]
+	if len(args) < 2 {
+		return nil, fmt.Errorf("%s watchdog requires an address", network)
+	}
+	d := &socketDog{network: network, addr: args[1], timeout: defaultTimeout}
+	if network == "tcp" {
+		if _, _, err := net.SplitHostPort(d.addr); err != nil {
+			return nil, fmt.Errorf("address %q must specify a host and a port", d.addr)
+		}
+	}
+	opts, rem := parseOptions(args[2:])
+	if len(rem) > 0 {
+		return nil, fmt.Errorf("invalid %s watchdog option %q", network, rem[0])
+	}
+	for k, v := range opts {
+		switch k {
+		case "send":
+			payload, err := unescapePayload(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid payload %q: %s", v, err)
+			}
+			d.send = []byte(payload)
+		case "expect":
+			re, err := regexp.Compile(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid expect regexp %q: %s", v, err)
+			}
+			d.expect = re
+		case "timeout":
+			t, err := strconv.Atoi(v)
+			if err != nil || t <= 0 {
+				return nil, fmt.Errorf("invalid timeout %q, must be a positive integer", v)
+			}
+			d.timeout = t
+		default:
+			return nil, fmt.Errorf("unknown %s watchdog option %q", network, k)
+		}
+	}
+	return d, nil
+}
+
+func (d *socketDog) check() error {
+	conn, err := dialTimeout(d.timeout)(d.network, d.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if len(d.send) > 0 {
+		if _, err := conn.Write(d.send); err != nil {
+			return err
+		}
+	}
+	if d.expect == nil {
+		return nil
+	}
+	var resp []byte
+	buf := make([]byte, 4096)
+	for len(resp) < socketDogMaxResponse {
+		n, err := conn.Read(buf)
+		resp = append(resp, buf[:n]...)
+		if d.expect.Match(resp) {
+			return nil
+		}
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				return fmt.Errorf("timed out after %s waiting for %q, got %q", time.Duration(d.timeout)*time.Second, d.expect, resp)
+			}
+			break
+		}
+	}
+	return fmt.Errorf("response %q does not match %q", resp, d.expect)
+}
+
+func (d *socketDog) String() string {
+	return fmt.Sprintf("%s: %s", d.network, d.addr)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// pingServer replies +PONG to PING lines and ignores anything else,
+// like a hung server would.
+func pingServer(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == "PING\r\n" {
+					conn.Write([]byte("+PONG\r\n"))
+				}
+			}
+		}()
+	}
+}
+
+func TestSocketWatchdog(t *testing.T) {
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	go pingServer(tl)
+	dir, err := ioutil.TempDir("", "governator-socket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "ping.sock")
+	ul, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ul.Close()
+	go pingServer(ul)
+	addr := tl.Addr().String()
+	tests := []watchdogTest{
+		{"tcp " + addr, "", ""},
+		{"tcp " + addr + " send=PING\\r\\n expect=^\\+PONG", "", ""},
+		{"tcp " + addr + " send=PONG\\r\\n expect=^\\+PONG timeout=1", "", "contains:timed out"},
+		{"unix " + sock + " send=PING\\r\\n expect=PONG", "", ""},
+		{"unix " + sock + " send=\"PING\\r\\n\" expect=\"^\\+PONG\\s*$\"", "", ""},
+		{"unix " + filepath.Join(dir, "missing.sock"), "", "contains:no such file"},
+		{"tcp localhost", "address \"localhost\" must specify a host and a port", ""},
+		{"tcp " + addr + " foo=bar", "unknown tcp watchdog option \"foo\"", ""},
+		{"unix " + sock + " expect=(", "contains:invalid expect regexp", ""},
+	}
+	for _, v := range tests {
+		t.Logf("testing wd config %q", v.config)
+		w := new(Watchdog)
+		err := w.Parse(v.config)
+		if !checkExpectedErr(t, err, v.perr) || err != nil {
+			continue
+		}
+		checkExpectedErr(t, w.Check(), v.cerr)
+	}
+}
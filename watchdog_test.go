@@ -1,11 +1,15 @@
 package main
 
 import (
+	"errors"
+	"io/ioutil"
 	"math/rand"
 	"net"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -82,7 +86,7 @@ func TestWatchdog(t *testing.T) {
 		{"run false", "", "exit status 1"},
 		{"run does-not-exist", "", "exec: \"does-not-exist\": executable file not found in $PATH"},
 		{"connect tcp://127.0.0.1:1", "", "contains:connection refused"},
-		{"invalid", "invalid watchdog \"invalid\" - available watchdogs are run, connect and get", ""},
+		{"invalid", "invalid watchdog \"invalid\" - available watchdogs are run, connect, get, http, tcp, unix, grpc, file, proc and keepalive", ""},
 		{"connect tcp://127.0.0.1:" + strconv.Itoa(np), "", ""},
 		{"get http://127.0.0.1:" + strconv.Itoa(hp), "", ""},
 		{"connect tcp://127.0.0.1:" + strconv.Itoa(np) + " 30", "", ""},
@@ -102,3 +106,220 @@ func TestWatchdog(t *testing.T) {
 		}
 	}
 }
+
+func TestWatchdogOptions(t *testing.T) {
+	w := new(Watchdog)
+	if err := w.Parse("interval=5 failures=3 successes=2 delay=30 run true"); err != nil {
+		t.Fatal(err)
+	}
+	if w.Interval != 5 || w.FailureThreshold != 3 || w.SuccessThreshold != 2 || w.InitialDelay != 30 {
+		t.Errorf("unexpected watchdog options %+v", w)
+	}
+	if err := w.Parse("failures=-1 run true"); err == nil {
+		t.Error("expecting an error with negative failures")
+	}
+	if err := w.Parse("foo=1 run true"); err == nil {
+		t.Error("expecting an error with an unknown option")
+	}
+}
+
+func TestWatchdogThresholds(t *testing.T) {
+	w := new(Watchdog)
+	if err := w.Parse("failures=3 successes=2 run true"); err != nil {
+		t.Fatal(err)
+	}
+	errFailed := errors.New("failed")
+	steps := []struct {
+		err    error
+		failed bool
+	}{
+		{errFailed, false},
+		{errFailed, false},
+		// failures must be consecutive
+		{nil, false},
+		{errFailed, false},
+		{errFailed, false},
+		{errFailed, true},
+		{errFailed, false},
+		{nil, false},
+		{errFailed, false},
+		{errFailed, false},
+		{errFailed, true},
+	}
+	for ii, v := range steps {
+		if failed, _ := w.update(v.err); failed != v.failed {
+			t.Errorf("step %d: expecting failed = %v, got %v", ii, v.failed, failed)
+		}
+	}
+}
+
+func TestWatchdogDelayAfterRestart(t *testing.T) {
+	g := prepareGovernatorTest(t)
+	defer afterGovernatorTest(t, g)
+	w := new(Watchdog)
+	if err := w.Parse("delay=60 run true"); err != nil {
+		t.Fatal(err)
+	}
+	name, err := g.AddService(&Config{
+		File:     "/non-existant",
+		Command:  "sleep 50000",
+		Name:     "sleep-delay",
+		Watchdog: w,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := g.serviceByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop(name)
+	now := time.Now()
+	if !w.checksDelayed(now) {
+		t.Fatal("checks should be delayed after starting")
+	}
+	// Pretend the delay has expired and kill the process, the
+	// service is restarted and the delay must be applied again
+	w.mu.Lock()
+	w.checkAfter = time.Time{}
+	w.mu.Unlock()
+	if w.checksDelayed(now) {
+		t.Fatal("checks should not be delayed")
+	}
+	s.mu.Lock()
+	started := s.Started
+	proc := s.Cmd.Process
+	s.mu.Unlock()
+	proc.Kill()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		restarted := s.State == StateStarted && s.Started.After(started)
+		s.mu.Unlock()
+		if restarted {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("service was not restarted")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if !w.checksDelayed(time.Now()) {
+		t.Error("checks should be delayed after the service was restarted")
+	}
+}
+
+func TestMultipleWatchdogs(t *testing.T) {
+	var cfg Config
+	data := "command: sleep 1\nwatchdog: run true\nwatchdogs:\n  - interval=10 run true\n  - failures=2 run false\n"
+	if err := decodeYAMLConfig([]byte(data), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	dogs := cfg.watchdogs()
+	if len(dogs) != 3 {
+		t.Fatalf("expecting 3 watchdogs, got %d", len(dogs))
+	}
+	if dogs[1].Interval != 10 || dogs[2].FailureThreshold != 2 {
+		t.Errorf("unexpected watchdogs %+v, %+v", dogs[1], dogs[2])
+	}
+}
+
+func TestRunWatchdog(t *testing.T) {
+	w := new(Watchdog)
+	if err := w.Parse("run timeout=1 sleep 10"); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	checkExpectedErr(t, w.Check(), "run watchdog timed out after 1s")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("timed out watchdog took %s", elapsed)
+	}
+	g := prepareGovernatorTest(t)
+	defer afterGovernatorTest(t, g)
+	dir, err := ioutil.TempDir("", "governator-run-watchdog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg := &Config{
+		File:    "/non-existant",
+		Command: "sleep 50000",
+		Name:    "sleep-run-watchdog",
+		Dir:     dir,
+		Env:     map[string]string{"WD_TEST": "hello"},
+	}
+	setLogger(t, cfg, "none")
+	var mu sync.Mutex
+	var lines []string
+	cfg.Log.Monitor = func(prefix string, b []byte) {
+		if prefix == "watchdog" {
+			mu.Lock()
+			lines = append(lines, string(b))
+			mu.Unlock()
+		}
+	}
+	name, err := g.AddService(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := g.serviceByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Parse("run sh -c 'echo $WD_TEST; pwd; printf partial >&2'"); err != nil {
+		t.Fatal(err)
+	}
+	w.dog.(serviceDog).setService(s)
+	check := func() {
+		mu.Lock()
+		lines = nil
+		mu.Unlock()
+		if err := w.Check(); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		output := strings.Join(lines, "")
+		for _, v := range []string{"hello\n", dir + "\n", "partial\n"} {
+			if !strings.Contains(output, v) {
+				t.Errorf("expecting %q in watchdog output %q", v, output)
+			}
+		}
+	}
+	// The service environment must be used even if it's not running
+	check()
+	if err := g.Start(name); err != nil {
+		t.Fatal(err)
+	}
+	defer g.Stop(name)
+	check()
+}
+
+func TestWatchdogHistory(t *testing.T) {
+	w := new(Watchdog)
+	if err := w.Parse("run false"); err != nil {
+		t.Fatal(err)
+	}
+	for ii := 0; ii < watchdogHistorySize+2; ii++ {
+		w.run()
+	}
+	history := w.History()
+	if len(history) != watchdogHistorySize {
+		t.Fatalf("expecting %d results, got %d", watchdogHistorySize, len(history))
+	}
+	for _, v := range history {
+		if v.Err == nil || v.Duration <= 0 {
+			t.Errorf("unexpected result %s", &v)
+		}
+	}
+	s := newService(&Config{Name: "history", Watchdog: w})
+	status := serviceStatus(s)
+	for _, v := range []string{"history - STOPPED\n", "watchdog run: [false] - 12 checks, 12 failures\n", "FAILED in"} {
+		if !strings.Contains(status, v) {
+			t.Errorf("expecting %q in status %q", v, status)
+		}
+	}
+}